@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/loopholelabs/drafter/pkg/bandwidth"
+	"github.com/loopholelabs/drafter/pkg/ipc"
+)
+
+func main() {
+	laddr := flag.String("laddr", "localhost:1500", "Address to listen on")
+
+	bandwidthLimit := flag.Int64("bandwidth-limit", 0, "Maximum number of bytes per second to share between all migrations on the host (0 to disable)")
+
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		done := make(chan os.Signal, 1)
+		signal.Notify(done, os.Interrupt)
+
+		<-done
+
+		log.Println("Exiting gracefully")
+
+		cancel()
+	}()
+
+	lis, err := net.Listen("tcp", *laddr)
+	if err != nil {
+		panic(err)
+	}
+
+	log.Println("Serving on", lis.Addr())
+
+	if err := ipc.ServeArbiter(ctx, lis, bandwidth.NewArbiter(*bandwidthLimit)); err != nil {
+		panic(err)
+	}
+
+	log.Println("Shutting down")
+}
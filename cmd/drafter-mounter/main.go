@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -171,6 +172,8 @@ func main() {
 
 	concurrency := flag.Int("concurrency", 1024, "Number of concurrent workers to use in migrations")
 
+	addedDevicesDir := flag.String("added-devices-dir", "", "Directory to store devices that the remote adds during the migration in (leave empty to reject added devices)")
+
 	flag.Parse()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -246,6 +249,12 @@ func main() {
 		})
 	}
 
+	// Devices that were added during the migration to this mounter are migrated onwards too
+	var (
+		addedDevicesLock sync.Mutex
+		addedDevices     = []CompositeDevices{}
+	)
+
 	migratedMounter, err := mounter.MigrateFromAndMount(
 		goroutineManager.Context(),
 		goroutineManager.Context(),
@@ -269,6 +278,42 @@ func main() {
 				log.Println("Completed migration of remote device", remoteDeviceID)
 			},
 
+			OnRemoteDeviceAdded: func(remoteDeviceID uint32, name string) string {
+				if strings.TrimSpace(*addedDevicesDir) == "" || !mounter.IsValidDeviceName(name) {
+					log.Println("Rejecting added remote device", remoteDeviceID, "with name", name)
+
+					return ""
+				}
+
+				log.Println("Accepting added remote device", remoteDeviceID, "with name", name)
+
+				base := filepath.Join(*addedDevicesDir, name)
+
+				addedDevicesLock.Lock()
+				defer addedDevicesLock.Unlock()
+
+				// The remote doesn't send the parameters it used to migrate the device, so we use the same defaults as for `--devices`
+				addedDevices = append(addedDevices, CompositeDevices{
+					Name: name,
+
+					Base: base,
+
+					BlockSize: 1024 * 64,
+
+					Expiry: time.Second,
+
+					MaxDirtyBlocks: 200,
+					MinCycles:      5,
+					MaxCycles:      20,
+
+					CycleThrottle: time.Millisecond * 500,
+
+					MakeMigratable: true,
+				})
+
+				return base
+			},
+
 			OnRemoteAllDevicesReceived: func() {
 				log.Println("Received all remote devices")
 			},
@@ -420,8 +465,12 @@ l:
 
 			log.Println("Migrating to", conn.RemoteAddr())
 
+			addedDevicesLock.Lock()
+			migratableDevices := slices.Concat(devices, addedDevices)
+			addedDevicesLock.Unlock()
+
 			makeMigratableDevices := []mounter.MakeMigratableDevice{}
-			for _, device := range devices {
+			for _, device := range migratableDevices {
 				if !device.MakeMigratable {
 					continue
 				}
@@ -446,7 +495,7 @@ l:
 			defer migratableMounter.Close()
 
 			migrateToDevices := []mounter.MigrateToDevice{}
-			for _, device := range devices {
+			for _, device := range migratableDevices {
 				if !device.MakeMigratable {
 					continue
 				}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -12,10 +13,13 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/loopholelabs/drafter/internal/utils"
+	"github.com/loopholelabs/drafter/pkg/bandwidth"
 	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/mounter"
 	"github.com/loopholelabs/drafter/pkg/packager"
@@ -46,6 +50,11 @@ type CompositeDevices struct {
 	Shared         bool `json:"shared"`
 }
 
+type migrationRequest struct {
+	conn     net.Conn
+	priority bandwidth.Priority
+}
+
 func main() {
 	rawFirecrackerBin := flag.String("firecracker-bin", "firecracker", "Firecracker binary")
 	rawJailerBin := flag.String("jailer-bin", "jailer", "Jailer binary (from Firecracker)")
@@ -203,11 +212,32 @@ func main() {
 
 	raddr := flag.String("raddr", "localhost:1337", "Remote address to connect to (leave empty to disable)")
 	laddr := flag.String("laddr", "localhost:1337", "Local address to listen on (leave empty to disable)")
+	rladdr := flag.String("rladdr", "", "Local address to listen on for the remote to connect to, i.e. from drafter-shell's migrate command (leave empty to disable) (ignored if --raddr is set)")
 
 	concurrency := flag.Int("concurrency", 1024, "Number of concurrent workers to use in migrations")
 
+	addedDevicesDir := flag.String("added-devices-dir", "", "Directory to store devices that the remote adds during the migration in (leave empty to reject added devices)")
+
+	bandwidthLimit := flag.Int64("bandwidth-limit", 0, "Maximum number of bytes per second to use when migrating to the remote (0 to disable) (only used if the drafter-arbiter becomes unavailable if --bandwidth-arbiter-raddr is set)")
+	bandwidthArbiterRaddr := flag.String("bandwidth-arbiter-raddr", "", "Remote address of a drafter-arbiter to share bandwidth with all migrations on the host (leave empty to only limit this peer's migrations)")
+	rawBandwidthPriority := flag.String("bandwidth-priority", bandwidth.PriorityNormal.String(), "Default bandwidth priority of migrations (background, normal, urgent or a number) (higher priorities preempt lower ones on the same drafter-arbiter)")
+	bandwidthWeight := flag.Int64("bandwidth-weight", 1, "Bandwidth weight of migrations (used to share bandwidth between migrations with the same priority on the same drafter-arbiter)")
+
+	controlNetwork := flag.String("control-network", "unix", "Network to listen on for control connections (unix or tcp)")
+	controlLaddr := flag.String("control-laddr", "", "Local address to listen on for control connections, i.e. from drafter-shell (leave empty to disable) (control connections aren't authenticated, so only use a unix socket or a loopback address)")
+	controlLogLines := flag.Int("control-log-lines", 1000, "Number of log lines to keep for control connections")
+
 	flag.Parse()
 
+	bandwidthPriority, err := bandwidth.ParsePriority(*rawBandwidthPriority)
+	if err != nil {
+		panic(err)
+	}
+
+	if *bandwidthWeight <= 0 {
+		panic(bandwidth.ErrInvalidWeight)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -216,6 +246,9 @@ func main() {
 		panic(err)
 	}
 
+	logBuffer := utils.NewLogBuffer(*controlLogLines)
+	log.SetOutput(io.MultiWriter(os.Stderr, logBuffer))
+
 	var errs error
 	defer func() {
 		if errs != nil {
@@ -251,6 +284,328 @@ func main() {
 		cancel()
 	}()
 
+	var (
+		controlLock sync.Mutex
+
+		// Held while checkpointing so that the VM isn't suspended or migrated during a checkpoint
+		checkpointLock sync.Mutex
+
+		status = ipc.ControlStatus{
+			State:      ipc.ControlStateStarting,
+			StateSince: time.Now(),
+		}
+		migrationProgress = map[uint32]*ipc.ControlMigrationProgress{}
+
+		controlDevices    = map[string]*ipc.ControlDevice{}
+		remoteDeviceNames = map[uint32]string{}
+		localDeviceNames  = map[uint32]string{}
+
+		resumedPeer *peer.ResumedPeer[struct{}, ipc.AgentServerRemote[struct{}], struct{}]
+
+		migrationRequests    = make(chan migrationRequest)
+		cancelMigration      func()
+		setMigrationPriority func(ctx context.Context, priority bandwidth.Priority) error
+		addMigrationDevice   func(device mounter.MigrateToAddedDevice) (*mounter.MigratedDevice, uint32, error)
+		suspending           bool
+
+		// Devices that were added during a migration to this peer are migrated onwards too
+		addedDevices = []CompositeDevices{}
+	)
+
+	for _, device := range devices {
+		controlDevices[device.Name] = &ipc.ControlDevice{
+			Name: device.Name,
+
+			MakeMigratable: device.MakeMigratable,
+			Shared:         device.Shared,
+		}
+	}
+
+	setState := func(state string) {
+		controlLock.Lock()
+		defer controlLock.Unlock()
+
+		status.State = state
+		status.StateSince = time.Now()
+	}
+
+	exposeDevice := func(deviceNames map[uint32]string, deviceID uint32, path string) {
+		controlLock.Lock()
+		defer controlLock.Unlock()
+
+		if device, ok := controlDevices[deviceNames[deviceID]]; ok {
+			device.Path = path
+		}
+	}
+
+	updateMigrationProgress := func(deviceID uint32, remote bool, update func(progress *ipc.ControlMigrationProgress)) {
+		controlLock.Lock()
+		defer controlLock.Unlock()
+
+		progress, ok := migrationProgress[deviceID]
+		if !ok {
+			progress = &ipc.ControlMigrationProgress{
+				DeviceID: deviceID,
+				Remote:   remote,
+			}
+
+			migrationProgress[deviceID] = progress
+		}
+
+		update(progress)
+	}
+
+	if strings.TrimSpace(*controlLaddr) != "" {
+		// Remove a stale socket from a previous run, but nothing else that might be at this path
+		if *controlNetwork == "unix" {
+			if info, err := os.Stat(*controlLaddr); err == nil && info.Mode()&os.ModeSocket != 0 {
+				if err := os.Remove(*controlLaddr); err != nil {
+					panic(err)
+				}
+			}
+		}
+
+		controlLis, err := net.Listen(*controlNetwork, *controlLaddr)
+		if err != nil {
+			panic(err)
+		}
+
+		// Control connections can migrate the VM and add any file as a device, so only our user may connect
+		if *controlNetwork == "unix" {
+			if err := os.Chmod(*controlLaddr, 0600); err != nil {
+				panic(err)
+			}
+		}
+
+		log.Println("Serving control on", controlLis.Addr())
+
+		controlHandlers := ipc.ControlServerHandlers{
+			Status: func(ctx context.Context) (ipc.ControlStatus, error) {
+				controlLock.Lock()
+				defer controlLock.Unlock()
+
+				s := status
+				s.MigrationProgress = []ipc.ControlMigrationProgress{}
+				for _, progress := range migrationProgress {
+					s.MigrationProgress = append(s.MigrationProgress, *progress)
+				}
+
+				slices.SortFunc(s.MigrationProgress, func(a, b ipc.ControlMigrationProgress) int {
+					return cmp.Compare(a.DeviceID, b.DeviceID)
+				})
+
+				return s, nil
+			},
+			Devices: func(ctx context.Context) ([]ipc.ControlDevice, error) {
+				controlLock.Lock()
+				defer controlLock.Unlock()
+
+				d := []ipc.ControlDevice{}
+				for _, device := range controlDevices {
+					d = append(d, *device)
+				}
+
+				slices.SortFunc(d, func(a, b ipc.ControlDevice) int {
+					return cmp.Compare(a.Name, b.Name)
+				})
+
+				return d, nil
+			},
+			Checkpoint: func(ctx context.Context) error {
+				checkpointLock.Lock()
+				defer checkpointLock.Unlock()
+
+				controlLock.Lock()
+				state := status.State
+				controlLock.Unlock()
+
+				// Migrations already msync the VM's memory themselves, and they suspend the VM concurrently
+				if state == ipc.ControlStateMigratingTo {
+					return ipc.ErrMigrationAlreadyInProgress
+				}
+
+				if state != ipc.ControlStateResumed {
+					return ipc.ErrVMNotResumed
+				}
+
+				before := time.Now()
+
+				if err := resumedPeer.Msync(ctx); err != nil {
+					return err
+				}
+
+				log.Println("Checkpoint:", time.Since(before))
+
+				return nil
+			},
+			Migrate: func(ctx context.Context, raddr string, priority int) error {
+				controlLock.Lock()
+				if status.State != ipc.ControlStateResumed {
+					defer controlLock.Unlock()
+
+					if status.State == ipc.ControlStateMigratingTo {
+						return ipc.ErrMigrationAlreadyInProgress
+					}
+
+					return ipc.ErrVMNotResumed
+				}
+				controlLock.Unlock()
+
+				conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", raddr)
+				if err != nil {
+					return err
+				}
+
+				// The migration loop only receives from this channel if no other migration is in progress
+				select {
+				case migrationRequests <- migrationRequest{
+					conn:     conn,
+					priority: bandwidth.Priority(priority),
+				}:
+					return nil
+
+				default:
+					_ = conn.Close() // We ignore errors here since we never used the connection
+
+					return ipc.ErrMigrationAlreadyInProgress
+				}
+			},
+			SetPriority: func(ctx context.Context, priority int) error {
+				controlLock.Lock()
+				setPriority := setMigrationPriority
+				controlLock.Unlock()
+
+				if setPriority == nil {
+					return ipc.ErrNoMigrationInProgress
+				}
+
+				if err := setPriority(ctx, bandwidth.Priority(priority)); err != nil {
+					return err
+				}
+
+				controlLock.Lock()
+				status.MigrationPriority = priority
+				controlLock.Unlock()
+
+				log.Println("Changed bandwidth priority of migration to", bandwidth.Priority(priority))
+
+				return nil
+			},
+			AddDevice: func(ctx context.Context, device ipc.ControlAddedDevice) (ipc.ControlDevice, error) {
+				controlLock.Lock()
+				addDevice := addMigrationDevice
+				_, exists := controlDevices[device.Name]
+				controlLock.Unlock()
+
+				if addDevice == nil {
+					return ipc.ControlDevice{}, ipc.ErrNoMigrationInProgress
+				}
+
+				if exists {
+					return ipc.ControlDevice{}, mounter.ErrDeviceAlreadyExists
+				}
+
+				migratedDevice, deviceID, err := addDevice(mounter.MigrateToAddedDevice{
+					Name: device.Name,
+
+					Base:    device.Base,
+					Overlay: device.Overlay,
+					State:   device.State,
+
+					BlockSize: device.BlockSize,
+
+					Expiry: device.Expiry,
+
+					MaxDirtyBlocks: device.MaxDirtyBlocks,
+					MinCycles:      device.MinCycles,
+					MaxCycles:      device.MaxCycles,
+
+					CycleThrottle: device.CycleThrottle,
+				})
+				if err != nil {
+					return ipc.ControlDevice{}, err
+				}
+
+				log.Println("Added device", device.Name, "as local device", deviceID, "at", migratedDevice.Path)
+
+				controlLock.Lock()
+				defer controlLock.Unlock()
+
+				controlDevice := &ipc.ControlDevice{
+					Name: migratedDevice.Name,
+					Path: migratedDevice.Path,
+
+					MakeMigratable: true,
+				}
+				controlDevices[controlDevice.Name] = controlDevice
+
+				// The device stays attached to the VM even if the migration is cancelled, so later migrations need to include it
+				addedDevices = append(addedDevices, CompositeDevices{
+					Name: device.Name,
+
+					Base:    device.Base,
+					Overlay: device.Overlay,
+					State:   device.State,
+
+					BlockSize: device.BlockSize,
+
+					Expiry: device.Expiry,
+
+					MaxDirtyBlocks: device.MaxDirtyBlocks,
+					MinCycles:      device.MinCycles,
+					MaxCycles:      device.MaxCycles,
+
+					CycleThrottle: device.CycleThrottle,
+
+					MakeMigratable: true,
+					Shared:         false,
+				})
+
+				return *controlDevice, nil
+			},
+			Cancel: func(ctx context.Context) error {
+				controlLock.Lock()
+				defer controlLock.Unlock()
+
+				if cancelMigration == nil {
+					return ipc.ErrNoMigrationInProgress
+				}
+
+				// Once the VM has been suspended, the destination is about to take over authority for its devices
+				if suspending {
+					return ipc.ErrVMAlreadySuspended
+				}
+
+				cancelMigration()
+
+				return nil
+			},
+			Logs: func(ctx context.Context, since int64, lines int) (ipc.ControlLogs, error) {
+				var l ipc.ControlLogs
+				if since < 0 {
+					l.Lines, l.Next = logBuffer.Tail(lines)
+				} else {
+					l.Lines, l.Next = logBuffer.Since(since)
+				}
+
+				return l, nil
+			},
+		}
+
+		// Msync doesn't write anything back to the devices with MAP_PRIVATE, so there is nothing to checkpoint
+		if *experimentalMapPrivate {
+			controlHandlers.Checkpoint = nil
+		}
+
+		controlServer := ipc.NewControlServer(controlHandlers)
+
+		goroutineManager.StartForegroundGoroutine(func(ctx context.Context) {
+			if err := ipc.ServeControl(ctx, controlLis, controlServer); err != nil {
+				panic(err)
+			}
+		})
+	}
+
 	firecrackerBin, err := exec.LookPath(*rawFirecrackerBin)
 	if err != nil {
 		panic(err)
@@ -274,6 +629,32 @@ func main() {
 
 		log.Println("Migrating from", conn.RemoteAddr())
 
+		readers = []io.Reader{conn}
+		writers = []io.Writer{conn}
+	} else if strings.TrimSpace(*rladdr) != "" {
+		rlis, err := net.Listen("tcp", *rladdr)
+		if err != nil {
+			panic(err)
+		}
+
+		log.Println("Waiting for remote on", rlis.Addr())
+
+		// Closing the listener interrupts `Accept` if we're cancelled while waiting for the remote
+		stopClosingListener := context.AfterFunc(goroutineManager.Context(), func() {
+			_ = rlis.Close() // We ignore errors here since we might interrupt a network connection
+		})
+
+		conn, err := rlis.Accept()
+		stopClosingListener()
+		_ = rlis.Close() // We ignore errors here since we only accept a single remote
+
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		log.Println("Migrating from", conn.RemoteAddr())
+
 		readers = []io.Reader{conn}
 		writers = []io.Writer{conn}
 	}
@@ -315,6 +696,11 @@ func main() {
 		panic(err)
 	}
 
+	controlLock.Lock()
+	status.VMPath = p.VMPath
+	status.VMPid = p.VMPid
+	controlLock.Unlock()
+
 	defer func() {
 		defer goroutineManager.CreateForegroundPanicCollector()()
 
@@ -344,6 +730,8 @@ func main() {
 		})
 	}
 
+	setState(ipc.ControlStateMigratingFrom)
+
 	migratedPeer, err := p.MigrateFrom(
 		goroutineManager.Context(),
 
@@ -355,9 +743,25 @@ func main() {
 		mounter.MigrateFromHooks{
 			OnRemoteDeviceReceived: func(remoteDeviceID uint32, name string) {
 				log.Println("Received remote device", remoteDeviceID, "with name", name)
+
+				controlLock.Lock()
+				defer controlLock.Unlock()
+
+				remoteDeviceNames[remoteDeviceID] = name
+
+				// Devices that the remote adds during the migration aren't part of our configuration
+				if _, ok := controlDevices[name]; !ok {
+					controlDevices[name] = &ipc.ControlDevice{
+						Name: name,
+					}
+				}
+
+				controlDevices[name].Remote = true
 			},
 			OnRemoteDeviceExposed: func(remoteDeviceID uint32, path string) {
 				log.Println("Exposed remote device", remoteDeviceID, "at", path)
+
+				exposeDevice(remoteDeviceNames, remoteDeviceID, path)
 			},
 			OnRemoteDeviceAuthorityReceived: func(remoteDeviceID uint32, customPayload []byte) {
 				log.Println("Received authority for remote device", remoteDeviceID)
@@ -366,6 +770,43 @@ func main() {
 				log.Println("Completed migration of remote device", remoteDeviceID)
 			},
 
+			OnRemoteDeviceAdded: func(remoteDeviceID uint32, name string) string {
+				if strings.TrimSpace(*addedDevicesDir) == "" || !mounter.IsValidDeviceName(name) {
+					log.Println("Rejecting added remote device", remoteDeviceID, "with name", name)
+
+					return ""
+				}
+
+				log.Println("Accepting added remote device", remoteDeviceID, "with name", name)
+
+				base := filepath.Join(*addedDevicesDir, name)
+
+				controlLock.Lock()
+				defer controlLock.Unlock()
+
+				// The remote doesn't send the parameters it used to migrate the device, so we use the same defaults as for `--devices`
+				addedDevices = append(addedDevices, CompositeDevices{
+					Name: name,
+
+					Base: base,
+
+					BlockSize: 1024 * 64,
+
+					Expiry: time.Second,
+
+					MaxDirtyBlocks: 200,
+					MinCycles:      5,
+					MaxCycles:      20,
+
+					CycleThrottle: time.Millisecond * 500,
+
+					MakeMigratable: true,
+					Shared:         false,
+				})
+
+				return base
+			},
+
 			OnRemoteAllDevicesReceived: func() {
 				log.Println("Received all remote devices")
 			},
@@ -375,9 +816,16 @@ func main() {
 
 			OnLocalDeviceRequested: func(localDeviceID uint32, name string) {
 				log.Println("Requested local device", localDeviceID, "with name", name)
+
+				controlLock.Lock()
+				defer controlLock.Unlock()
+
+				localDeviceNames[localDeviceID] = name
 			},
 			OnLocalDeviceExposed: func(localDeviceID uint32, path string) {
 				log.Println("Exposed local device", localDeviceID, "at", path)
+
+				exposeDevice(localDeviceNames, localDeviceID, path)
 			},
 
 			OnLocalAllDevicesRequested: func() {
@@ -412,9 +860,11 @@ func main() {
 		}
 	})
 
+	setState(ipc.ControlStateResuming)
+
 	before := time.Now()
 
-	resumedPeer, err := migratedPeer.Resume(
+	resumedPeer, err = migratedPeer.Resume(
 		goroutineManager.Context(),
 
 		*resumeTimeout,
@@ -455,242 +905,402 @@ func main() {
 		panic(err)
 	}
 
-	if strings.TrimSpace(*laddr) == "" {
-		bubbleSignals = true
+	setState(ipc.ControlStateResumed)
 
-		select {
-		case <-goroutineManager.Context().Done():
-			return
+	if strings.TrimSpace(*laddr) != "" {
+		var (
+			closeLock sync.Mutex
+			closed    bool
+		)
+		lis, err := net.Listen("tcp", *laddr)
+		if err != nil {
+			panic(err)
+		}
+		defer func() {
+			defer goroutineManager.CreateForegroundPanicCollector()()
 
-		case <-done:
-			before = time.Now()
+			closeLock.Lock()
 
-			if err := resumedPeer.SuspendAndCloseAgentServer(goroutineManager.Context(), *resumeTimeout); err != nil {
+			closed = true
+
+			closeLock.Unlock()
+
+			if err := lis.Close(); err != nil {
 				panic(err)
 			}
+		}()
 
-			log.Println("Suspend:", time.Since(before))
+		log.Println("Serving on", lis.Addr())
 
-			log.Println("Shutting down")
+		goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
+			for {
+				conn, err := lis.Accept()
+				if err != nil {
+					closeLock.Lock()
+					defer closeLock.Unlock()
 
-			return
-		}
-	}
+					if closed && errors.Is(err, net.ErrClosed) { // Don't treat closed errors as errors if we closed the connection
+						if err := goroutineManager.Context().Err(); err != nil {
+							panic(err)
+						}
 
-	var (
-		closeLock sync.Mutex
-		closed    bool
-	)
-	lis, err := net.Listen("tcp", *laddr)
-	if err != nil {
-		panic(err)
+						return
+					}
+
+					panic(err)
+				}
+
+				// The migration loop only receives from this channel if no other migration is in progress
+				select {
+				case migrationRequests <- migrationRequest{
+					conn:     conn,
+					priority: bandwidthPriority,
+				}:
+				default:
+					log.Println("Rejecting migration to", conn.RemoteAddr(), "since another migration is in progress")
+
+					_ = conn.Close() // We ignore errors here since we never used the connection
+				}
+			}
+		})
 	}
-	defer func() {
-		defer goroutineManager.CreateForegroundPanicCollector()()
 
-		closeLock.Lock()
+	// Devices can be added during a migration, so we collect the devices to migrate right before every migration
+	getMigratableDevices := func() ([]mounter.MakeMigratableDevice, []mounter.MigrateToDevice) {
+		controlLock.Lock()
+		defer controlLock.Unlock()
 
-		closed = true
+		makeMigratableDevices := []mounter.MakeMigratableDevice{}
+		migrateToDevices := []mounter.MigrateToDevice{}
+		for _, device := range slices.Concat(devices, addedDevices) {
+			if !device.MakeMigratable || device.Shared {
+				continue
+			}
 
-		closeLock.Unlock()
+			makeMigratableDevices = append(makeMigratableDevices, mounter.MakeMigratableDevice{
+				Name: device.Name,
 
-		if err := lis.Close(); err != nil {
-			panic(err)
+				Expiry: device.Expiry,
+			})
+
+			migrateToDevices = append(migrateToDevices, mounter.MigrateToDevice{
+				Name: device.Name,
+
+				MaxDirtyBlocks: device.MaxDirtyBlocks,
+				MinCycles:      device.MinCycles,
+				MaxCycles:      device.MaxCycles,
+
+				CycleThrottle: device.CycleThrottle,
+			})
 		}
-	}()
 
-	log.Println("Serving on", lis.Addr())
+		return makeMigratableDevices, migrateToDevices
+	}
 
-	var (
-		ready       = make(chan struct{})
-		signalReady = sync.OnceFunc(func() {
-			close(ready) // We can safely close() this channel since the caller only runs once/is `sync.OnceFunc`d
-		})
-	)
+	// Migrations share this arbiter unless they share bandwidth with the whole host through a drafter-arbiter
+	arbiter := bandwidth.NewArbiter(*bandwidthLimit)
 
-	var conn net.Conn
-	goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
-		conn, err = lis.Accept()
+	connectArbiter := func(ctx context.Context, priority bandwidth.Priority) (*ipc.ConnectedArbiterClient, error) {
+		arbiterConn, err := (&net.Dialer{}).DialContext(ctx, "tcp", *bandwidthArbiterRaddr)
 		if err != nil {
-			closeLock.Lock()
-			defer closeLock.Unlock()
+			return nil, err
+		}
 
-			if closed && errors.Is(err, net.ErrClosed) { // Don't treat closed errors as errors if we closed the connection
-				if err := goroutineManager.Context().Err(); err != nil {
-					panic(err)
-				}
+		arbiterClient, err := ipc.ConnectArbiterClient(ctx, arbiterConn)
+		if err != nil {
+			_ = arbiterConn.Close() // We ignore errors here since we never used the connection
 
-				return
-			}
+			return nil, err
+		}
 
-			panic(err)
+		if err := arbiterClient.Remote.Register(ctx, int(priority), *bandwidthWeight); err != nil {
+			arbiterClient.Close()
+
+			return nil, err
 		}
 
-		signalReady()
-	})
+		return arbiterClient, nil
+	}
 
-	bubbleSignals = true
+	// Returns false if the migration was cancelled or aborted and the VM is still running on this peer
+	migrateTo := func(request migrationRequest) bool {
+		conn := request.conn
+		defer conn.Close()
 
-	select {
-	case <-goroutineManager.Context().Done():
-		return
+		log.Println("Migrating to", conn.RemoteAddr())
 
-	case <-done:
-		before = time.Now()
+		migrateCtx, cancelMigrateCtx := context.WithCancel(goroutineManager.Context())
+		defer cancelMigrateCtx()
 
-		if err := resumedPeer.SuspendAndCloseAgentServer(goroutineManager.Context(), *resumeTimeout); err != nil {
-			panic(err)
+		// Wait for an ongoing checkpoint to finish before we start migrating
+		checkpointLock.Lock()
+		controlLock.Lock()
+		status.State = ipc.ControlStateMigratingTo
+		status.StateSince = time.Now()
+		status.MigrationAddr = conn.RemoteAddr().String()
+		migrationProgress = map[uint32]*ipc.ControlMigrationProgress{}
+		cancelMigration = func() {
+			cancelMigrateCtx()
+
+			_ = conn.Close() // We ignore errors here since we might interrupt a network connection
 		}
+		controlLock.Unlock()
+		checkpointLock.Unlock()
 
-		log.Println("Suspend:", time.Since(before))
+		defer func() {
+			controlLock.Lock()
+			defer controlLock.Unlock()
 
-		log.Println("Shutting down")
+			status.MigrationAddr = ""
+			cancelMigration = nil
+			setMigrationPriority = nil
+			addMigrationDevice = nil
+		}()
 
-		return
+		// If a drafter-arbiter is configured, this session is only used if it becomes unavailable during the migration
+		session, err := arbiter.NewSession(request.priority, *bandwidthWeight)
+		if err != nil {
+			panic(err)
+		}
 
-	case <-ready:
-		break
-	}
+		var (
+			writer      io.Writer
+			setPriority func(ctx context.Context, priority bandwidth.Priority) error
+		)
+		if strings.TrimSpace(*bandwidthArbiterRaddr) == "" {
+			writer = session.Writer(migrateCtx, conn)
+			setPriority = func(ctx context.Context, priority bandwidth.Priority) error {
+				session.SetPriority(priority)
 
-	defer conn.Close()
+				return nil
+			}
+		} else {
+			arbiterClient, err := connectArbiter(migrateCtx, request.priority)
+			if err != nil {
+				// The VM hasn't been touched yet, so we can keep it running on this peer
+				log.Println("Aborting migration to", conn.RemoteAddr(), "since the bandwidth arbiter is unavailable:", err)
 
-	log.Println("Migrating to", conn.RemoteAddr())
+				setState(ipc.ControlStateResumed)
 
-	makeMigratableDevices := []mounter.MakeMigratableDevice{}
-	for _, device := range devices {
-		if !device.MakeMigratable || device.Shared {
-			continue
+				return false
+			}
+			defer arbiterClient.Close()
+
+			// Losing the drafter-arbiter must not fail a migration that might have already touched the VM
+			writer = bandwidth.NewWriter(migrateCtx, bandwidth.NewFallback(
+				bandwidth.NewLease(arbiterClient.Remote.Acquire, bandwidth.DefaultLeaseSize).Acquire,
+				session.Acquire,
+				func(err error) {
+					log.Println("Falling back to --bandwidth-limit for migration to", conn.RemoteAddr(), "since the bandwidth arbiter became unavailable:", err)
+				},
+			), conn)
+			setPriority = func(ctx context.Context, priority bandwidth.Priority) error {
+				session.SetPriority(priority)
+
+				return arbiterClient.Remote.SetPriority(ctx, int(priority))
+			}
 		}
 
-		makeMigratableDevices = append(makeMigratableDevices, mounter.MakeMigratableDevice{
-			Name: device.Name,
+		controlLock.Lock()
+		status.MigrationPriority = int(request.priority)
+		setMigrationPriority = setPriority
+		controlLock.Unlock()
 
-			Expiry: device.Expiry,
-		})
-	}
+		makeMigratableDevices, migrateToDevices := getMigratableDevices()
 
-	migratablePeer, err := resumedPeer.MakeMigratable(
-		goroutineManager.Context(),
+		migratablePeer, err := resumedPeer.MakeMigratable(
+			migrateCtx,
 
-		makeMigratableDevices,
-	)
+			makeMigratableDevices,
+		)
 
-	if err != nil {
-		panic(err)
-	}
+		if err != nil {
+			panic(err)
+		}
 
-	defer migratablePeer.Close()
+		defer migratablePeer.Close()
 
-	migrateToDevices := []mounter.MigrateToDevice{}
-	for _, device := range devices {
-		if !device.MakeMigratable || device.Shared {
-			continue
-		}
+		controlLock.Lock()
+		addMigrationDevice = migratablePeer.AddDevice
+		controlLock.Unlock()
 
-		migrateToDevices = append(migrateToDevices, mounter.MigrateToDevice{
-			Name: device.Name,
+		before = time.Now()
+		if err := migratablePeer.MigrateTo(
+			migrateCtx,
+
+			migrateToDevices,
+
+			*resumeTimeout,
+			*concurrency,
+
+			[]io.Reader{conn},
+			[]io.Writer{writer},
+
+			peer.MigrateToHooks{
+				OnBeforeGetDirtyBlocks: func(deviceID uint32, remote bool) {
+					if remote {
+						log.Println("Getting dirty blocks for remote device", deviceID)
+					} else {
+						log.Println("Getting dirty blocks for local device", deviceID)
+					}
+				},
+
+				OnBeforeSuspend: func() {
+					controlLock.Lock()
+					defer controlLock.Unlock()
+
+					suspending = true
+					status.State = ipc.ControlStateSuspending
+					status.StateSince = time.Now()
+
+					before = time.Now()
+				},
+				OnAfterSuspend: func() {
+					log.Println("Suspend:", time.Since(before))
+
+					setState(ipc.ControlStateSuspended)
+				},
+
+				OnBeforeSendDeviceAuthority: func(deviceID uint32, remote bool) []byte {
+					var customPayload []byte
+
+					if remote {
+						log.Println("Sending authority for remote device", deviceID, "with custom payload", customPayload)
+					} else {
+						log.Println("Sending authority for local device", deviceID, "with custom payload", customPayload)
+					}
+
+					return customPayload
+				},
+
+				OnDeviceSent: func(deviceID uint32, remote bool) {
+					if remote {
+						log.Println("Sent remote device", deviceID)
+					} else {
+						log.Println("Sent local device", deviceID)
+					}
+
+					updateMigrationProgress(deviceID, remote, func(progress *ipc.ControlMigrationProgress) {})
+				},
+				OnDeviceAuthoritySent: func(deviceID uint32, remote bool) {
+					if remote {
+						log.Println("Sent authority for remote device", deviceID)
+					} else {
+						log.Println("Sent authority for local device", deviceID)
+					}
+
+					updateMigrationProgress(deviceID, remote, func(progress *ipc.ControlMigrationProgress) {
+						progress.AuthoritySent = true
+					})
+				},
+				OnDeviceInitialMigrationProgress: func(deviceID uint32, remote bool, ready, total int) {
+					if remote {
+						log.Println("Migrated", ready, "of", total, "initial blocks for remote device", deviceID)
+					} else {
+						log.Println("Migrated", ready, "of", total, "initial blocks for local device", deviceID)
+					}
+
+					updateMigrationProgress(deviceID, remote, func(progress *ipc.ControlMigrationProgress) {
+						progress.ReadyBlocks = ready
+						progress.TotalBlocks = total
+					})
+				},
+				OnDeviceContinousMigrationProgress: func(deviceID uint32, remote bool, delta int) {
+					if remote {
+						log.Println("Migrated", delta, "continous blocks for remote device", deviceID)
+					} else {
+						log.Println("Migrated", delta, "continous blocks for local device", deviceID)
+					}
+
+					updateMigrationProgress(deviceID, remote, func(progress *ipc.ControlMigrationProgress) {
+						progress.DirtyBlocks += delta
+					})
+				},
+				OnDeviceFinalMigrationProgress: func(deviceID uint32, remote bool, delta int) {
+					if remote {
+						log.Println("Migrated", delta, "final blocks for remote device", deviceID)
+					} else {
+						log.Println("Migrated", delta, "final blocks for local device", deviceID)
+					}
+
+					updateMigrationProgress(deviceID, remote, func(progress *ipc.ControlMigrationProgress) {
+						progress.DirtyBlocks += delta
+					})
+				},
+				OnDeviceMigrationCompleted: func(deviceID uint32, remote bool) {
+					if remote {
+						log.Println("Completed migration of remote device", deviceID)
+					} else {
+						log.Println("Completed migration of local device", deviceID)
+					}
+
+					updateMigrationProgress(deviceID, remote, func(progress *ipc.ControlMigrationProgress) {
+						progress.Completed = true
+					})
+				},
+
+				OnAllDevicesSent: func() {
+					log.Println("Sent all devices")
+				},
+				OnAllMigrationsCompleted: func() {
+					log.Println("Completed all device migrations")
+				},
+			},
+		); err != nil {
+			controlLock.Lock()
+			cancelled := migrateCtx.Err() != nil && goroutineManager.Context().Err() == nil && !suspending
+			controlLock.Unlock()
 
-			MaxDirtyBlocks: device.MaxDirtyBlocks,
-			MinCycles:      device.MinCycles,
-			MaxCycles:      device.MaxCycles,
+			// The VM keeps running on this peer if the migration was cancelled before it was suspended
+			if cancelled {
+				log.Println("Cancelled migration to", conn.RemoteAddr())
 
-			CycleThrottle: device.CycleThrottle,
-		})
+				setState(ipc.ControlStateResumed)
+
+				return false
+			}
+
+			panic(err)
+		}
+
+		return true
 	}
 
-	before = time.Now()
-	if err := migratablePeer.MigrateTo(
-		goroutineManager.Context(),
+	bubbleSignals = true
 
-		migrateToDevices,
+	for {
+		select {
+		case <-goroutineManager.Context().Done():
+			return
 
-		*resumeTimeout,
-		*concurrency,
+		case <-done:
+			// Wait for an ongoing checkpoint to finish before we suspend
+			checkpointLock.Lock()
+			setState(ipc.ControlStateSuspending)
+			checkpointLock.Unlock()
 
-		[]io.Reader{conn},
-		[]io.Writer{conn},
+			before = time.Now()
 
-		peer.MigrateToHooks{
-			OnBeforeGetDirtyBlocks: func(deviceID uint32, remote bool) {
-				if remote {
-					log.Println("Getting dirty blocks for remote device", deviceID)
-				} else {
-					log.Println("Getting dirty blocks for local device", deviceID)
-				}
-			},
+			if err := resumedPeer.SuspendAndCloseAgentServer(goroutineManager.Context(), *resumeTimeout); err != nil {
+				panic(err)
+			}
 
-			OnBeforeSuspend: func() {
-				before = time.Now()
-			},
-			OnAfterSuspend: func() {
-				log.Println("Suspend:", time.Since(before))
-			},
+			log.Println("Suspend:", time.Since(before))
 
-			OnBeforeSendDeviceAuthority: func(deviceID uint32, remote bool) []byte {
-				var customPayload []byte
+			setState(ipc.ControlStateSuspended)
 
-				if remote {
-					log.Println("Sending authority for remote device", deviceID, "with custom payload", customPayload)
-				} else {
-					log.Println("Sending authority for local device", deviceID, "with custom payload", customPayload)
-				}
+			log.Println("Shutting down")
 
-				return customPayload
-			},
+			return
 
-			OnDeviceSent: func(deviceID uint32, remote bool) {
-				if remote {
-					log.Println("Sent remote device", deviceID)
-				} else {
-					log.Println("Sent local device", deviceID)
-				}
-			},
-			OnDeviceAuthoritySent: func(deviceID uint32, remote bool) {
-				if remote {
-					log.Println("Sent authority for remote device", deviceID)
-				} else {
-					log.Println("Sent authority for local device", deviceID)
-				}
-			},
-			OnDeviceInitialMigrationProgress: func(deviceID uint32, remote bool, ready, total int) {
-				if remote {
-					log.Println("Migrated", ready, "of", total, "initial blocks for remote device", deviceID)
-				} else {
-					log.Println("Migrated", ready, "of", total, "initial blocks for local device", deviceID)
-				}
-			},
-			OnDeviceContinousMigrationProgress: func(deviceID uint32, remote bool, delta int) {
-				if remote {
-					log.Println("Migrated", delta, "continous blocks for remote device", deviceID)
-				} else {
-					log.Println("Migrated", delta, "continous blocks for local device", deviceID)
-				}
-			},
-			OnDeviceFinalMigrationProgress: func(deviceID uint32, remote bool, delta int) {
-				if remote {
-					log.Println("Migrated", delta, "final blocks for remote device", deviceID)
-				} else {
-					log.Println("Migrated", delta, "final blocks for local device", deviceID)
-				}
-			},
-			OnDeviceMigrationCompleted: func(deviceID uint32, remote bool) {
-				if remote {
-					log.Println("Completed migration of remote device", deviceID)
-				} else {
-					log.Println("Completed migration of local device", deviceID)
-				}
-			},
+		case request := <-migrationRequests:
+			if !migrateTo(request) {
+				continue
+			}
 
-			OnAllDevicesSent: func() {
-				log.Println("Sent all devices")
-			},
-			OnAllMigrationsCompleted: func() {
-				log.Println("Completed all device migrations")
-			},
-		},
-	); err != nil {
-		panic(err)
-	}
+			log.Println("Shutting down")
 
-	log.Println("Shutting down")
+			return
+		}
+	}
 }
@@ -11,7 +11,10 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 
+	"github.com/loopholelabs/drafter/pkg/bandwidth"
+	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/packager"
 	"github.com/loopholelabs/drafter/pkg/registry"
 	"github.com/loopholelabs/goroutine-manager/pkg/manager"
@@ -63,8 +66,22 @@ func main() {
 
 	concurrency := flag.Int("concurrency", 1024, "Number of concurrent workers to use in migrations")
 
+	bandwidthLimit := flag.Int64("bandwidth-limit", 0, "Maximum number of bytes per second to share between all concurrent migrations (0 to disable) (only used if the drafter-arbiter becomes unavailable if --bandwidth-arbiter-raddr is set)")
+	bandwidthArbiterRaddr := flag.String("bandwidth-arbiter-raddr", "", "Remote address of a drafter-arbiter to share bandwidth with all migrations on the host (leave empty to only share bandwidth between this registry's migrations)")
+	rawBandwidthPriority := flag.String("bandwidth-priority", bandwidth.PriorityBackground.String(), "Bandwidth priority of migrations (background, normal, urgent or a number) (higher priorities preempt lower ones)")
+	bandwidthWeight := flag.Int64("bandwidth-weight", 1, "Bandwidth weight of migrations (used to share bandwidth between migrations with the same priority)")
+
 	flag.Parse()
 
+	bandwidthPriority, err := bandwidth.ParsePriority(*rawBandwidthPriority)
+	if err != nil {
+		panic(err)
+	}
+
+	if *bandwidthWeight <= 0 {
+		panic(bandwidth.ErrInvalidWeight)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -73,6 +90,8 @@ func main() {
 		panic(err)
 	}
 
+	arbiter := bandwidth.NewArbiter(*bandwidthLimit)
+
 	lis, err := net.Listen("tcp", *laddr)
 	if err != nil {
 		panic(err)
@@ -163,6 +182,41 @@ l:
 				defer deferFunc()
 			}
 
+			// If a drafter-arbiter is configured, this session is only used if it becomes unavailable during the migration
+			session, err := arbiter.NewSession(bandwidthPriority, *bandwidthWeight)
+			if err != nil {
+				panic(err)
+			}
+
+			var writer io.Writer
+			if strings.TrimSpace(*bandwidthArbiterRaddr) == "" {
+				writer = session.Writer(goroutineManager.Context(), conn)
+			} else {
+				arbiterConn, err := (&net.Dialer{}).DialContext(goroutineManager.Context(), "tcp", *bandwidthArbiterRaddr)
+				if err != nil {
+					panic(err)
+				}
+				defer arbiterConn.Close()
+
+				arbiterClient, err := ipc.ConnectArbiterClient(goroutineManager.Context(), arbiterConn)
+				if err != nil {
+					panic(err)
+				}
+				defer arbiterClient.Close()
+
+				if err := arbiterClient.Remote.Register(goroutineManager.Context(), int(bandwidthPriority), *bandwidthWeight); err != nil {
+					panic(err)
+				}
+
+				writer = bandwidth.NewWriter(goroutineManager.Context(), bandwidth.NewFallback(
+					bandwidth.NewLease(arbiterClient.Remote.Acquire, bandwidth.DefaultLeaseSize).Acquire,
+					session.Acquire,
+					func(err error) {
+						log.Println("Falling back to --bandwidth-limit for migration to", conn.RemoteAddr(), "since the bandwidth arbiter became unavailable:", err)
+					},
+				), conn)
+			}
+
 			if err := registry.MigrateTo(
 				goroutineManager.Context(),
 
@@ -170,7 +224,7 @@ l:
 				*concurrency,
 
 				[]io.Reader{conn},
-				[]io.Writer{conn},
+				[]io.Writer{writer},
 
 				registry.MigrateToHooks{
 					OnDeviceSent: func(deviceID uint32) {
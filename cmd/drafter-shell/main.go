@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/loopholelabs/drafter/internal/terminal"
+	"github.com/loopholelabs/drafter/pkg/bandwidth"
+	"github.com/loopholelabs/drafter/pkg/ipc"
+)
+
+var commands = []string{
+	"status",
+	"devices",
+	"checkpoint",
+	"migrate",
+	"priority background",
+	"priority normal",
+	"priority urgent",
+	"add-device",
+	"cancel",
+	"logs tail",
+	"logs follow",
+	"help",
+	"exit",
+}
+
+const help = `Commands:
+  status                Show the state of the VM and the progress of the current migration
+  devices               List the VM's devices and where they are exposed
+  checkpoint            Msync the VM's memory to its memory device (not supported with --experimental-map-private or during migrations)
+  migrate <raddr> [p]   Migrate the VM to the peer listening on <raddr> with bandwidth priority [p] (default normal)
+  priority <p>          Change the bandwidth priority of the current migration (background, normal, urgent or a number)
+  add-device <name> <base> [overlay state]
+                        Add the disk at <base> to the current migration (only before the VM is suspended)
+  cancel                Cancel the current migration (only before the VM is suspended)
+  logs tail [lines]     Show the last log lines of the peer (default 20)
+  logs follow           Follow the logs of the peer until a key is pressed
+  help                  Show this help
+  exit                  Exit the shell`
+
+func complete(line string) []string {
+	candidates := []string{}
+	for _, command := range commands {
+		if strings.HasPrefix(command, line) {
+			// Only complete up to the end of the word that is currently being typed
+			rest := command[len(line):]
+			if i := strings.Index(rest, " "); i > 0 {
+				command = line + rest[:i]
+			}
+
+			if len(candidates) == 0 || candidates[len(candidates)-1] != command {
+				candidates = append(candidates, command)
+			}
+		}
+	}
+
+	return candidates
+}
+
+func run(ctx context.Context, remote ipc.ControlClientRemote, editor *terminal.LineEditor, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "status":
+		status, err := remote.Status(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		fmt.Println("State:", status.State, "(since", time.Since(status.StateSince).Round(time.Second), "ago)")
+		fmt.Println("VM:", status.VMPath, "with PID", status.VMPid)
+
+		if status.MigrationAddr != "" {
+			fmt.Println("Migrating to:", status.MigrationAddr, "with bandwidth priority", bandwidth.Priority(status.MigrationPriority))
+		}
+
+		if len(status.MigrationProgress) > 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+			fmt.Fprintln(w, "DEVICE\tREMOTE\tINITIAL BLOCKS\tDIRTY BLOCKS\tAUTHORITY SENT\tCOMPLETED")
+			for _, progress := range status.MigrationProgress {
+				fmt.Fprintf(w, "%v\t%v\t%v/%v\t%v\t%v\t%v\n", progress.DeviceID, progress.Remote, progress.ReadyBlocks, progress.TotalBlocks, progress.DirtyBlocks, progress.AuthoritySent, progress.Completed)
+			}
+
+			if err := w.Flush(); err != nil {
+				return false, err
+			}
+		}
+
+	case "devices":
+		devices, err := remote.Devices(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(w, "NAME\tPATH\tREMOTE\tMIGRATABLE\tSHARED")
+		for _, device := range devices {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", device.Name, device.Path, device.Remote, device.MakeMigratable, device.Shared)
+		}
+
+		if err := w.Flush(); err != nil {
+			return false, err
+		}
+
+	case "checkpoint":
+		before := time.Now()
+
+		if err := remote.Checkpoint(ctx); err != nil {
+			if strings.Contains(err.Error(), ipc.ErrControlCommandNotSupported.Error()) {
+				return false, errors.New("checkpoint not supported by this peer, its memory isn't written back to its memory device (i.e. because it uses --experimental-map-private)")
+			}
+
+			return false, err
+		}
+
+		fmt.Println("Msynced the VM's memory to its memory device in", time.Since(before))
+
+	case "migrate":
+		if len(args) < 2 || len(args) > 3 {
+			return false, errors.New("usage: migrate <raddr> [priority]")
+		}
+
+		priority := bandwidth.PriorityNormal
+		if len(args) > 2 {
+			var err error
+			priority, err = bandwidth.ParsePriority(args[2])
+			if err != nil {
+				return false, err
+			}
+		}
+
+		if err := remote.Migrate(ctx, args[1], int(priority)); err != nil {
+			return false, err
+		}
+
+		fmt.Println("Started migration to", args[1], "with bandwidth priority", priority)
+
+	case "priority":
+		if len(args) != 2 {
+			return false, errors.New("usage: priority <priority>")
+		}
+
+		priority, err := bandwidth.ParsePriority(args[1])
+		if err != nil {
+			return false, err
+		}
+
+		if err := remote.SetPriority(ctx, int(priority)); err != nil {
+			return false, err
+		}
+
+		fmt.Println("Changed bandwidth priority of migration to", priority)
+
+	case "add-device":
+		if len(args) != 3 && len(args) != 5 {
+			return false, errors.New("usage: add-device <name> <base> [overlay state]")
+		}
+
+		device := ipc.ControlAddedDevice{
+			Name: args[1],
+
+			Base: args[2],
+
+			BlockSize: 1024 * 64,
+
+			Expiry: time.Second,
+
+			MaxDirtyBlocks: 200,
+			MinCycles:      5,
+			MaxCycles:      20,
+
+			CycleThrottle: time.Millisecond * 500,
+		}
+		if len(args) == 5 {
+			device.Overlay = args[3]
+			device.State = args[4]
+		}
+
+		controlDevice, err := remote.AddDevice(ctx, device)
+		if err != nil {
+			return false, err
+		}
+
+		fmt.Println("Added device", controlDevice.Name, "at", controlDevice.Path, "to migration")
+
+	case "cancel":
+		if err := remote.Cancel(ctx); err != nil {
+			return false, err
+		}
+
+		fmt.Println("Cancelled migration")
+
+	case "logs":
+		if len(args) < 2 {
+			return false, errors.New("usage: logs tail [lines] | logs follow")
+		}
+
+		switch args[1] {
+		case "tail":
+			lines := 20
+			if len(args) > 2 {
+				var err error
+				lines, err = strconv.Atoi(args[2])
+				if err != nil {
+					return false, err
+				}
+			}
+
+			logs, err := remote.Logs(ctx, -1, lines)
+			if err != nil {
+				return false, err
+			}
+
+			for _, line := range logs.Lines {
+				fmt.Println(line)
+			}
+
+		case "follow":
+			fmt.Println("Following logs, press any key to stop")
+
+			if err := editor.UntilKeypress(ctx, func(ctx context.Context) error {
+				logs, err := remote.Logs(ctx, -1, 0)
+				if err != nil {
+					return err
+				}
+
+				ticker := time.NewTicker(time.Millisecond * 500)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+
+					case <-ticker.C:
+						logs, err = remote.Logs(ctx, logs.Next, 0)
+						if err != nil {
+							return err
+						}
+
+						for _, line := range logs.Lines {
+							fmt.Println(line)
+						}
+					}
+				}
+			}); err != nil {
+				return false, err
+			}
+
+		default:
+			return false, errors.New("usage: logs tail [lines] | logs follow")
+		}
+
+	case "help":
+		fmt.Println(help)
+
+	case "exit", "quit":
+		return true, nil
+
+	default:
+		return false, fmt.Errorf("unknown command %q, run `help` to list commands", args[0])
+	}
+
+	return false, nil
+}
+
+func main() {
+	network := flag.String("network", "unix", "Network of the peer's control server (unix or tcp)")
+	raddr := flag.String("raddr", filepath.Join("out", "control.sock"), "Remote address of the peer's control server to connect to")
+
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, *network, *raddr)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	controlClient, err := ipc.ConnectControlClient(ctx, conn)
+	if err != nil {
+		panic(err)
+	}
+	defer controlClient.Close()
+
+	editor := terminal.NewLineEditor(os.Stdin, os.Stdout, "drafter> ", complete)
+
+	// Run a single command non-interactively, i.e. `drafter-shell status`
+	if flag.NArg() > 0 {
+		if _, err := run(ctx, controlClient.Remote, editor, flag.Args()); err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
+	log.Println("Connected to", conn.RemoteAddr())
+
+	for {
+		line, err := editor.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			panic(err)
+		}
+
+		exit, err := run(ctx, controlClient.Remote, editor, strings.Fields(line))
+		if err != nil {
+			fmt.Println("Error:", err)
+
+			continue
+		}
+
+		if exit {
+			return
+		}
+	}
+}
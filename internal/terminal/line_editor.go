@@ -0,0 +1,353 @@
+package terminal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	ErrCouldNotGetTerminalAttributes = errors.New("could not get terminal attributes")
+	ErrCouldNotSetTerminalAttributes = errors.New("could not set terminal attributes")
+	ErrCouldNotReadFromTerminal      = errors.New("could not read from terminal")
+)
+
+const (
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyBackspace = 8
+	keyTab       = 9
+	keyLineFeed  = 10
+	keyEnter     = 13
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+const (
+	escapeSequenceTimeout   = 50 // Milliseconds to wait for the rest of an escape sequence before treating Escape as a key press
+	maxEscapeSequenceLength = 16
+)
+
+// LineEditor reads lines from a terminal with history and tab completion; if the input isn't a terminal, it
+// falls back to reading plain lines so that it can be used in scripts
+type LineEditor struct {
+	in  *os.File
+	out io.Writer
+
+	prompt   string
+	complete func(line string) []string
+
+	isTerminal bool
+	reader     *bufio.Reader
+
+	history []string
+}
+
+// NewLineEditor creates a line editor; `complete` returns the full lines that the current line can be completed to
+func NewLineEditor(
+	in *os.File,
+	out io.Writer,
+
+	prompt string,
+	complete func(line string) []string,
+) *LineEditor {
+	_, err := unix.IoctlGetTermios(int(in.Fd()), unix.TCGETS)
+
+	return &LineEditor{
+		in:  in,
+		out: out,
+
+		prompt:   prompt,
+		complete: complete,
+
+		isTerminal: err == nil,
+		reader:     bufio.NewReader(in),
+	}
+}
+
+func (e *LineEditor) makeRaw() (restore func(), err error) {
+	fd := int(e.in.Fd())
+
+	original, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotGetTerminalAttributes, err)
+	}
+
+	raw := *original
+	raw.Iflag &^= unix.ICRNL | unix.IXON
+	raw.Lflag &^= unix.ECHO | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, errors.Join(ErrCouldNotSetTerminalAttributes, err)
+	}
+
+	return func() {
+		_ = unix.IoctlSetTermios(fd, unix.TCSETS, original) // We ignore errors here since there is nothing left to restore
+	}, nil
+}
+
+func (e *LineEditor) redraw(line string) {
+	_, _ = io.WriteString(e.out, "\r\x1b[K"+e.prompt+line) // We ignore errors here since the output is best-effort
+}
+
+// ReadLine reads the next line; it returns `io.EOF` if the input is closed or Ctrl-D is pressed on an empty line
+func (e *LineEditor) ReadLine() (string, error) {
+	if !e.isTerminal {
+		line, err := e.reader.ReadString('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+			return "", err
+		}
+
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	restore, err := e.makeRaw()
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	var (
+		line          string
+		historyIndex  = len(e.history)
+		lastKeyWasTab bool
+	)
+
+	e.redraw(line)
+
+	for {
+		key, err := e.reader.ReadByte()
+		if err != nil {
+			return "", errors.Join(ErrCouldNotReadFromTerminal, err)
+		}
+
+		isTab := key == keyTab
+
+		switch key {
+		case keyCtrlC:
+			_, _ = io.WriteString(e.out, "^C\r\n")
+
+			line = ""
+			historyIndex = len(e.history)
+
+		case keyCtrlD:
+			if line == "" {
+				_, _ = io.WriteString(e.out, "\r\n")
+
+				return "", io.EOF
+			}
+
+		case keyEnter, keyLineFeed:
+			_, _ = io.WriteString(e.out, "\r\n")
+
+			if strings.TrimSpace(line) != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != line) {
+				e.history = append(e.history, line)
+			}
+
+			return line, nil
+
+		case keyBackspace, keyDelete:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+
+		case keyCtrlU:
+			line = ""
+
+		case keyTab:
+			if e.complete == nil {
+				break
+			}
+
+			candidates := e.complete(line)
+			if len(candidates) == 0 {
+				break
+			}
+
+			if len(candidates) == 1 {
+				line = candidates[0] + " "
+
+				break
+			}
+
+			if prefix := commonPrefix(candidates); len(prefix) > len(line) {
+				line = prefix
+
+				break
+			}
+
+			// Only list the candidates if Tab is pressed twice without making progress
+			if lastKeyWasTab {
+				_, _ = io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+			}
+
+		case keyEscape:
+			sequence, err := e.readEscapeSequence()
+			if err != nil {
+				return "", err
+			}
+
+			switch sequence {
+			case "[A", "OA":
+				if historyIndex > 0 {
+					historyIndex--
+					line = e.history[historyIndex]
+				}
+
+			case "[B", "OB":
+				if historyIndex < len(e.history)-1 {
+					historyIndex++
+					line = e.history[historyIndex]
+				} else {
+					historyIndex = len(e.history)
+					line = ""
+				}
+			}
+
+		default:
+			if key >= ' ' {
+				line += string(key)
+			}
+		}
+
+		lastKeyWasTab = isTab
+
+		e.redraw(line)
+	}
+}
+
+// readEscapeSequence reads the rest of an escape sequence, i.e. `[A` for the up arrow key or `[3~` for the delete key;
+// it returns an empty sequence if Escape was pressed on its own
+func (e *LineEditor) readEscapeSequence() (string, error) {
+	sequence := []byte{}
+	for len(sequence) < maxEscapeSequenceLength {
+		pending, err := e.pending(escapeSequenceTimeout)
+		if err != nil {
+			return "", err
+		}
+
+		if !pending {
+			break
+		}
+
+		b, err := e.reader.ReadByte()
+		if err != nil {
+			return "", errors.Join(ErrCouldNotReadFromTerminal, err)
+		}
+
+		sequence = append(sequence, b)
+
+		// SS3 sequences (`O` followed by a single byte) and Alt+key have a fixed length; CSI sequences (`[`) end with a
+		// final byte after their parameter and intermediate bytes, i.e. `A` or `~`
+		if len(sequence) == 1 && b != '[' && b != 'O' {
+			break
+		}
+
+		if len(sequence) == 2 && sequence[0] == 'O' {
+			break
+		}
+
+		if len(sequence) >= 2 && sequence[0] == '[' && b >= 0x40 && b <= 0x7e {
+			break
+		}
+	}
+
+	return string(sequence), nil
+}
+
+// pending checks if there is input to read within `timeout` milliseconds
+func (e *LineEditor) pending(timeout int) (bool, error) {
+	if e.reader.Buffered() > 0 {
+		return true, nil
+	}
+
+	fds := []unix.PollFd{{Fd: int32(e.in.Fd()), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, timeout)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+
+			return false, errors.Join(ErrCouldNotReadFromTerminal, err)
+		}
+
+		return n > 0, nil
+	}
+}
+
+// UntilKeypress calls `fn` with a context that is cancelled once any key is pressed
+func (e *LineEditor) UntilKeypress(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !e.isTerminal {
+		return fn(ctx)
+	}
+
+	restore, err := e.makeRaw()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	fnCtx, cancelFnCtx := context.WithCancel(ctx)
+	defer cancelFnCtx()
+
+	// We poll instead of blocking on a read so that this goroutine can't steal input from the next `ReadLine` call
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		defer cancelFnCtx()
+
+		fds := []unix.PollFd{{Fd: int32(e.in.Fd()), Events: unix.POLLIN}}
+		for e.reader.Buffered() == 0 {
+			if fnCtx.Err() != nil {
+				return
+			}
+
+			n, err := unix.Poll(fds, 100)
+			if err != nil && !errors.Is(err, unix.EINTR) {
+				return
+			}
+
+			if n > 0 {
+				break
+			}
+		}
+
+		// We ignore errors here since we only use the key press as a signal
+		if key, err := e.reader.ReadByte(); err == nil && key == keyEscape {
+			_, _ = e.readEscapeSequence() // Keys like the arrow keys send an escape sequence, which must not end up in the next line
+		}
+	}()
+
+	err = fn(fnCtx)
+	interrupted := fnCtx.Err() != nil
+
+	cancelFnCtx()
+	<-polled
+
+	// Errors that are caused by interrupting `fn` aren't errors for the caller
+	if err != nil && !interrupted {
+		return err
+	}
+
+	return nil
+}
+
+func commonPrefix(candidates []string) string {
+	prefix := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	return prefix
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"bytes"
+	"sync"
+)
+
+// LogBuffer keeps the last lines written to it so that they can be tailed later on
+type LogBuffer struct {
+	lock sync.Mutex
+
+	lines   []string
+	partial []byte
+
+	// Number of complete lines ever written; used as a cursor for `Since`
+	written int64
+}
+
+func NewLogBuffer(size int) *LogBuffer {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &LogBuffer{
+		lines: make([]string, 0, size),
+	}
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.partial = append(b.partial, p...)
+
+	for {
+		i := bytes.IndexByte(b.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := string(b.partial[:i])
+		b.partial = b.partial[i+1:]
+
+		if len(b.lines) == cap(b.lines) {
+			copy(b.lines, b.lines[1:])
+			b.lines = b.lines[:len(b.lines)-1]
+		}
+		b.lines = append(b.lines, line)
+
+		b.written++
+	}
+
+	return len(p), nil
+}
+
+// Tail returns up to `n` of the most recent lines, or none if `n` is zero or less, and the cursor to pass to `Since`
+// to get the lines written after them
+func (b *LogBuffer) Tail(n int) ([]string, int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if n <= 0 {
+		return []string{}, b.written
+	}
+
+	if n > len(b.lines) {
+		n = len(b.lines)
+	}
+
+	return append([]string{}, b.lines[len(b.lines)-n:]...), b.written
+}
+
+// Since returns the lines written after `cursor` that are still buffered and the cursor to pass to the next call
+func (b *LogBuffer) Since(cursor int64) ([]string, int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	n := b.written - cursor
+	if n <= 0 {
+		return []string{}, b.written
+	}
+
+	if n > int64(len(b.lines)) {
+		n = int64(len(b.lines))
+	}
+
+	return append([]string{}, b.lines[len(b.lines)-int(n):]...), b.written
+}
@@ -0,0 +1,303 @@
+package bandwidth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Priority int
+
+const (
+	// Background replication traffic, i.e. continous pre-copying to standby hosts
+	PriorityBackground = Priority(0)
+	// Regular, operator-initiated migrations
+	PriorityNormal = Priority(1)
+	// Evacuations that need to finish as soon as possible, i.e. before a host is drained
+	PriorityUrgent = Priority(2)
+)
+
+// ParsePriority parses either a priority's name, i.e. `urgent`, or its numeric value
+func ParsePriority(value string) (Priority, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "background":
+		return PriorityBackground, nil
+
+	case "normal":
+		return PriorityNormal, nil
+
+	case "urgent":
+		return PriorityUrgent, nil
+	}
+
+	priority, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, errors.Join(ErrInvalidPriority, err)
+	}
+
+	return Priority(priority), nil
+}
+
+func (priority Priority) String() string {
+	switch priority {
+	case PriorityBackground:
+		return "background"
+
+	case PriorityNormal:
+		return "normal"
+
+	case PriorityUrgent:
+		return "urgent"
+	}
+
+	return strconv.Itoa(int(priority))
+}
+
+const (
+	// How often waiting sessions re-check the arbiter for newly available bandwidth
+	scheduleInterval = time.Millisecond * 10
+	// How much unused bandwidth can accumulate while no session is waiting
+	maximumBurst = time.Millisecond * 100
+)
+
+// Arbiter shares a fixed amount of bandwidth between multiple concurrent migrations on a host.
+// Sessions with a higher priority preempt all sessions with a lower priority; sessions with the
+// same priority share the available bandwidth proportionally to their weight. Bandwidth that
+// can't be used by higher priorities is passed down to lower priorities.
+type Arbiter struct {
+	bytesPerSecond int64
+
+	lock       sync.Mutex
+	tokens     int64
+	lastRefill time.Time
+	waiters    []*waiter
+}
+
+type waiter struct {
+	session *Session
+
+	remaining int64
+	ready     chan struct{}
+}
+
+// NewArbiter creates a new arbiter which allows at most `bytesPerSecond` bytes to be written per second across
+// all sessions; a value of zero or less disables the limit
+func NewArbiter(bytesPerSecond int64) *Arbiter {
+	return &Arbiter{
+		bytesPerSecond: bytesPerSecond,
+
+		lastRefill: time.Now(),
+		waiters:    []*waiter{},
+	}
+}
+
+// NewSession registers a new migration with the arbiter
+func (arbiter *Arbiter) NewSession(priority Priority, weight int64) (*Session, error) {
+	if weight <= 0 {
+		return nil, ErrInvalidWeight
+	}
+
+	session := &Session{
+		arbiter: arbiter,
+
+		weight: weight,
+	}
+	session.priority.Store(int64(priority))
+
+	return session, nil
+}
+
+// schedule refills the token bucket and distributes the available tokens to the waiting sessions; the caller needs to hold the lock
+func (arbiter *Arbiter) schedule() {
+	now := time.Now()
+
+	// We only move the last refill forward if we've added at least one token so that low rates don't get truncated to zero
+	if refill := int64(float64(arbiter.bytesPerSecond) * now.Sub(arbiter.lastRefill).Seconds()); refill > 0 {
+		arbiter.tokens += refill
+		arbiter.lastRefill = now
+	}
+
+	if len(arbiter.waiters) == 0 {
+		if burst := int64(float64(arbiter.bytesPerSecond) * maximumBurst.Seconds()); arbiter.tokens > burst {
+			arbiter.tokens = burst
+		}
+
+		return
+	}
+
+	// Group waiters by priority, highest priority first
+	priorities := map[Priority][]*waiter{}
+	for _, w := range arbiter.waiters {
+		priority := w.session.Priority()
+
+		priorities[priority] = append(priorities[priority], w)
+	}
+
+	sortedPriorities := []Priority{}
+	for priority := range priorities {
+		sortedPriorities = append(sortedPriorities, priority)
+	}
+	sort.Slice(sortedPriorities, func(i, j int) bool {
+		return sortedPriorities[i] > sortedPriorities[j]
+	})
+
+	for _, priority := range sortedPriorities {
+		if arbiter.tokens <= 0 {
+			break
+		}
+
+		// Weighted fair sharing within a priority; we repeat this until either all tokens are used
+		// or all waiters are satisfied so that tokens a waiter doesn't need are redistributed
+		pending := priorities[priority]
+		for arbiter.tokens > 0 && len(pending) > 0 {
+			totalWeight := int64(0)
+			for _, w := range pending {
+				totalWeight += w.session.weight
+			}
+
+			available := arbiter.tokens
+			stillPending := []*waiter{}
+			for _, w := range pending {
+				share := max(available*w.session.weight/totalWeight, 1)
+				share = min(share, w.remaining, arbiter.tokens)
+
+				w.remaining -= share
+				arbiter.tokens -= share
+
+				if w.remaining > 0 {
+					stillPending = append(stillPending, w)
+				}
+			}
+
+			pending = stillPending
+		}
+	}
+
+	waiters := []*waiter{}
+	for _, w := range arbiter.waiters {
+		if w.remaining <= 0 {
+			close(w.ready) // We can safely close() this channel since we remove the waiter from the queue right after
+
+			continue
+		}
+
+		waiters = append(waiters, w)
+	}
+	arbiter.waiters = waiters
+}
+
+func (arbiter *Arbiter) remove(w *waiter) {
+	for i, candidate := range arbiter.waiters {
+		if candidate == w {
+			arbiter.waiters = append(arbiter.waiters[:i], arbiter.waiters[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// Session is a single migration's share of an arbiter
+type Session struct {
+	arbiter *Arbiter
+
+	weight   int64
+	priority atomic.Int64
+}
+
+func (session *Session) Priority() Priority {
+	return Priority(session.priority.Load())
+}
+
+// SetPriority changes the priority of the session, i.e. to escalate background replication to an evacuation;
+// this also affects writes that are already waiting for bandwidth
+func (session *Session) SetPriority(priority Priority) {
+	session.priority.Store(int64(priority))
+}
+
+// Acquire blocks until the session is allowed to write `n` bytes
+func (session *Session) Acquire(ctx context.Context, n int) error {
+	arbiter := session.arbiter
+	if arbiter.bytesPerSecond <= 0 || n <= 0 {
+		return nil
+	}
+
+	w := &waiter{
+		session: session,
+
+		remaining: int64(n),
+		ready:     make(chan struct{}),
+	}
+
+	arbiter.lock.Lock()
+	arbiter.waiters = append(arbiter.waiters, w)
+	arbiter.schedule()
+	arbiter.lock.Unlock()
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ready:
+			return nil
+
+		case <-ctx.Done():
+			arbiter.lock.Lock()
+			defer arbiter.lock.Unlock()
+
+			// We might have been scheduled concurrently
+			select {
+			case <-w.ready:
+				return nil
+
+			default:
+			}
+
+			arbiter.remove(w)
+
+			// Tokens that were already granted to this waiter can be re-used by other sessions
+			arbiter.tokens += int64(n) - w.remaining
+
+			return errors.Join(ErrBandwidthContextCancelled, ctx.Err())
+
+		case <-ticker.C:
+			arbiter.lock.Lock()
+			arbiter.schedule()
+			arbiter.lock.Unlock()
+		}
+	}
+}
+
+// Writer wraps `w` so that all writes to it are scheduled by the arbiter
+func (session *Session) Writer(ctx context.Context, w io.Writer) io.Writer {
+	return NewWriter(ctx, session.Acquire, w)
+}
+
+// NewWriter wraps `w` so that every write first acquires its size from `acquire`, i.e. `Session.Acquire` or `Lease.Acquire`
+func NewWriter(ctx context.Context, acquire func(ctx context.Context, n int) error, w io.Writer) io.Writer {
+	return &acquiringWriter{
+		ctx:     ctx,
+		acquire: acquire,
+		w:       w,
+	}
+}
+
+type acquiringWriter struct {
+	ctx     context.Context
+	acquire func(ctx context.Context, n int) error
+	w       io.Writer
+}
+
+func (s *acquiringWriter) Write(p []byte) (int, error) {
+	if err := s.acquire(s.ctx, len(p)); err != nil {
+		return 0, errors.Join(ErrCouldNotAcquireBandwidth, err)
+	}
+
+	return s.w.Write(p)
+}
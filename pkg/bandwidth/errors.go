@@ -0,0 +1,10 @@
+package bandwidth
+
+import "errors"
+
+var (
+	ErrBandwidthContextCancelled = errors.New("bandwidth context cancelled")
+	ErrInvalidWeight             = errors.New("invalid weight")
+	ErrCouldNotAcquireBandwidth  = errors.New("could not acquire bandwidth")
+	ErrInvalidPriority           = errors.New("invalid priority")
+)
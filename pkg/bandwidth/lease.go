@@ -0,0 +1,75 @@
+package bandwidth
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultLeaseSize is small enough to not noticeably delay preemption, but large enough to not need a round trip per block
+const DefaultLeaseSize = 1024 * 256
+
+// Lease acquires bandwidth in chunks of at least `size` bytes, i.e. from an arbiter in another process, so that
+// small writes don't need a round trip each. Bandwidth that was leased but isn't written yet is lost once the
+// lease isn't used anymore, so `size` should be small compared to the arbiter's rate.
+type Lease struct {
+	acquire func(ctx context.Context, n int) error
+	size    int
+
+	lock      sync.Mutex
+	available int
+}
+
+func NewLease(acquire func(ctx context.Context, n int) error, size int) *Lease {
+	return &Lease{
+		acquire: acquire,
+		size:    size,
+	}
+}
+
+// Acquire blocks until `n` bytes are available from the lease, acquiring a new chunk if required
+func (lease *Lease) Acquire(ctx context.Context, n int) error {
+	lease.lock.Lock()
+	defer lease.lock.Unlock()
+
+	if n <= lease.available {
+		lease.available -= n
+
+		return nil
+	}
+
+	chunk := max(n-lease.available, lease.size)
+	if err := lease.acquire(ctx, chunk); err != nil {
+		return err
+	}
+
+	lease.available += chunk - n
+
+	return nil
+}
+
+// NewFallback returns an acquire function that uses `acquire` until it fails, i.e. because a drafter-arbiter became
+// unavailable during a migration, and `fallback` from then on so that the migration can continue; `onFallback` is
+// called once with the error that caused the switch
+func NewFallback(
+	acquire func(ctx context.Context, n int) error,
+	fallback func(ctx context.Context, n int) error,
+	onFallback func(err error),
+) func(ctx context.Context, n int) error {
+	var failed atomic.Bool
+
+	return func(ctx context.Context, n int) error {
+		if !failed.Load() {
+			err := acquire(ctx, n)
+			if err == nil || ctx.Err() != nil {
+				return err
+			}
+
+			if failed.CompareAndSwap(false, true) && onFallback != nil {
+				onFallback(err)
+			}
+		}
+
+		return fallback(ctx, n)
+	}
+}
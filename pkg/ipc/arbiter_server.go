@@ -0,0 +1,175 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/loopholelabs/drafter/pkg/bandwidth"
+	"github.com/loopholelabs/goroutine-manager/pkg/manager"
+	"github.com/pojntfx/panrpc/go/pkg/rpc"
+)
+
+var (
+	ErrCouldNotAcceptArbiterClient = errors.New("could not accept arbiter client")
+	ErrArbiterSessionRegistered    = errors.New("arbiter session already registered")
+	ErrArbiterSessionNotRegistered = errors.New("arbiter session not registered")
+)
+
+// The RPCs the arbiter client can call on this server; every connection is a single migration's session
+// See https://github.com/pojntfx/panrpc/tree/main?tab=readme-ov-file#5-calling-the-clients-rpcs-from-the-server
+type ArbiterServerLocal struct {
+	ctx     context.Context
+	arbiter *bandwidth.Arbiter
+
+	lock    sync.Mutex
+	session *bandwidth.Session
+}
+
+// The RPCs this server can call on the arbiter client
+// See https://github.com/pojntfx/panrpc/tree/main?tab=readme-ov-file#4-calling-the-servers-rpcs-from-the-client
+type ArbiterServerRemote struct{}
+
+func (l *ArbiterServerLocal) getSession() (*bandwidth.Session, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.session == nil {
+		return nil, ErrArbiterSessionNotRegistered
+	}
+
+	return l.session, nil
+}
+
+func (l *ArbiterServerLocal) Register(ctx context.Context, priority int, weight int64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.session != nil {
+		return ErrArbiterSessionRegistered
+	}
+
+	session, err := l.arbiter.NewSession(bandwidth.Priority(priority), weight)
+	if err != nil {
+		return err
+	}
+
+	l.session = session
+
+	return nil
+}
+
+func (l *ArbiterServerLocal) SetPriority(ctx context.Context, priority int) error {
+	session, err := l.getSession()
+	if err != nil {
+		return err
+	}
+
+	session.SetPriority(bandwidth.Priority(priority))
+
+	return nil
+}
+
+func (l *ArbiterServerLocal) Acquire(ctx context.Context, n int) error {
+	session, err := l.getSession()
+	if err != nil {
+		return err
+	}
+
+	// Stop waiting for bandwidth once the client disconnects so that it can be used by other sessions
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stop := context.AfterFunc(l.ctx, cancel)
+	defer stop()
+
+	return session.Acquire(ctx, n)
+}
+
+// ServeArbiter shares `arbiter` with the arbiter clients on `lis` until `ctx` is cancelled; the listener is closed on return
+func ServeArbiter(
+	ctx context.Context,
+
+	lis net.Listener,
+	arbiter *bandwidth.Arbiter,
+) (errs error) {
+	goroutineManager := manager.NewGoroutineManager(
+		ctx,
+		&errs,
+		manager.GoroutineManagerHooks{},
+	)
+	defer goroutineManager.Wait()
+	defer goroutineManager.StopAllGoroutines()
+	defer goroutineManager.CreateBackgroundPanicCollector()()
+
+	// This goroutine will not leak on function return because it selects on `goroutineManager.Context().Done()` internally
+	goroutineManager.StartForegroundGoroutine(func(ctx context.Context) {
+		<-ctx.Done()
+
+		_ = lis.Close() // We ignore errors here since we might interrupt a network connection
+	})
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			// Don't treat closed errors as errors if we closed the listener
+			if goroutineManager.Context().Err() != nil && errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			panic(errors.Join(ErrCouldNotAcceptArbiterClient, err))
+		}
+
+		// We don't track this because arbiter clients can disconnect at any time without affecting the server
+		goroutineManager.StartBackgroundGoroutine(func(ctx context.Context) {
+			defer conn.Close()
+
+			connCtx, cancelConnCtx := context.WithCancel(ctx)
+			defer cancelConnCtx()
+
+			registry := rpc.NewRegistry[ArbiterServerRemote, cbor.RawMessage](
+				&ArbiterServerLocal{
+					ctx:     connCtx,
+					arbiter: arbiter,
+				},
+
+				&rpc.RegistryHooks{},
+			)
+
+			encoder := cbor.NewEncoder(conn)
+			decoder := cbor.NewDecoder(conn)
+
+			// We ignore errors here since an arbiter client disconnecting isn't an error for the server
+			_ = registry.LinkStream(
+				connCtx,
+
+				func(v rpc.Message[cbor.RawMessage]) error {
+					return encoder.Encode(v)
+				},
+				func(v *rpc.Message[cbor.RawMessage]) error {
+					return decoder.Decode(v)
+				},
+
+				func(v any) (cbor.RawMessage, error) {
+					b, err := cbor.Marshal(v)
+					if err != nil {
+						return nil, errors.Join(ErrCouldNotMarshalJSON, err)
+					}
+
+					return cbor.RawMessage(b), nil
+				},
+				func(data cbor.RawMessage, v any) error {
+					if err := cbor.Unmarshal([]byte(data), v); err != nil {
+						return errors.Join(ErrCouldNotUnmarshalJSON, err)
+					}
+
+					return nil
+				},
+
+				nil,
+			)
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/loopholelabs/goroutine-manager/pkg/manager"
+	"github.com/pojntfx/panrpc/go/pkg/rpc"
+)
+
+var (
+	ErrControlServerDisconnected = errors.New("control server disconnected")
+	ErrControlContextCancelled   = errors.New("control context cancelled")
+)
+
+// The RPCs the control server can call on this client
+// See https://github.com/pojntfx/panrpc/tree/main?tab=readme-ov-file#5-calling-the-clients-rpcs-from-the-server
+type ControlClientLocal struct{}
+
+// The RPCs this client can call on the control server
+// See https://github.com/pojntfx/panrpc/tree/main?tab=readme-ov-file#4-calling-the-servers-rpcs-from-the-client
+type ControlClientRemote struct {
+	Status      func(ctx context.Context) (ControlStatus, error)
+	Devices     func(ctx context.Context) ([]ControlDevice, error)
+	Checkpoint  func(ctx context.Context) error
+	Migrate     func(ctx context.Context, raddr string, priority int) error
+	SetPriority func(ctx context.Context, priority int) error
+	AddDevice   func(ctx context.Context, device ControlAddedDevice) (ControlDevice, error)
+	Cancel      func(ctx context.Context) error
+	Logs        func(ctx context.Context, since int64, lines int) (ControlLogs, error)
+}
+
+type ConnectedControlClient struct {
+	Remote ControlClientRemote
+
+	Wait  func() error
+	Close func()
+}
+
+// ConnectControlClient links a control client to the control server on the other end of `conn`;
+// the connection is closed once the client is closed
+func ConnectControlClient(
+	ctx context.Context,
+
+	conn net.Conn,
+) (connectedControlClient *ConnectedControlClient, errs error) {
+	connectedControlClient = &ConnectedControlClient{
+		Wait: func() error {
+			return nil
+		},
+		Close: func() {},
+	}
+
+	goroutineManager := manager.NewGoroutineManager(
+		ctx,
+		&errs,
+		manager.GoroutineManagerHooks{},
+	)
+	defer goroutineManager.Wait()
+	defer goroutineManager.StopAllGoroutines()
+	defer goroutineManager.CreateBackgroundPanicCollector()()
+
+	var closeLock sync.Mutex
+	closed := false
+
+	linkCtx, cancelLinkCtx := context.WithCancelCause(ctx) // This resource outlives the current scope, so we use the external context
+
+	connectedControlClient.Close = func() {
+		closeLock.Lock()
+		defer closeLock.Unlock()
+
+		closed = true
+
+		cancelLinkCtx(goroutineManager.GetErrGoroutineStopped())
+
+		_ = conn.Close() // We ignore errors here since we might interrupt a network connection
+	}
+
+	var (
+		ready       = make(chan struct{})
+		signalReady = sync.OnceFunc(func() {
+			close(ready) // We can safely close() this channel since the caller only runs once/is `sync.OnceFunc`d
+		})
+	)
+
+	registry := rpc.NewRegistry[ControlClientRemote, cbor.RawMessage](
+		&ControlClientLocal{},
+
+		&rpc.RegistryHooks{
+			OnClientConnect: func(remoteID string) {
+				signalReady()
+			},
+		},
+	)
+
+	connectedControlClient.Wait = sync.OnceValue(func() error {
+		defer cancelLinkCtx(nil)
+
+		encoder := cbor.NewEncoder(conn)
+		decoder := cbor.NewDecoder(conn)
+
+		if err := registry.LinkStream(
+			linkCtx,
+
+			func(v rpc.Message[cbor.RawMessage]) error {
+				return encoder.Encode(v)
+			},
+			func(v *rpc.Message[cbor.RawMessage]) error {
+				return decoder.Decode(v)
+			},
+
+			func(v any) (cbor.RawMessage, error) {
+				b, err := cbor.Marshal(v)
+				if err != nil {
+					return nil, errors.Join(ErrCouldNotMarshalJSON, err)
+				}
+
+				return cbor.RawMessage(b), nil
+			},
+			func(data cbor.RawMessage, v any) error {
+				if err := cbor.Unmarshal([]byte(data), v); err != nil {
+					return errors.Join(ErrCouldNotUnmarshalJSON, err)
+				}
+
+				return nil
+			},
+
+			nil,
+		); err != nil {
+			closeLock.Lock()
+			defer closeLock.Unlock()
+
+			// Don't treat closed errors as errors if we closed the connection
+			if !closed {
+				return errors.Join(ErrControlServerDisconnected, ErrCouldNotLinkRegistry, err)
+			}
+
+			return ctx.Err()
+		}
+
+		return nil
+	})
+
+	// It is safe to start a background goroutine here since we return a wait function
+	// Despite returning a wait function, we still need to start this goroutine however so that any errors
+	// we get as we're waiting for a connection are caught
+	goroutineManager.StartBackgroundGoroutine(func(_ context.Context) {
+		if err := connectedControlClient.Wait(); err != nil {
+			panic(errors.Join(ErrControlContextCancelled, err))
+		}
+	})
+
+	select {
+	case <-goroutineManager.Context().Done():
+		if err := goroutineManager.Context().Err(); err != nil {
+			panic(errors.Join(ErrControlContextCancelled, err))
+		}
+
+		return
+	case <-ready:
+		break
+	}
+
+	found := false
+	if err := registry.ForRemotes(func(remoteID string, r ControlClientRemote) error {
+		connectedControlClient.Remote = r
+		found = true
+
+		return nil
+	}); err != nil {
+		panic(err)
+	}
+
+	if !found {
+		panic(ErrNoRemoteFound)
+	}
+
+	return
+}
@@ -0,0 +1,258 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/loopholelabs/goroutine-manager/pkg/manager"
+	"github.com/pojntfx/panrpc/go/pkg/rpc"
+)
+
+var (
+	ErrCouldNotAcceptControlClient = errors.New("could not accept control client")
+	ErrControlCommandNotSupported  = errors.New("control command not supported")
+	ErrMigrationAlreadyInProgress  = errors.New("migration already in progress")
+	ErrNoMigrationInProgress       = errors.New("no migration in progress")
+	ErrVMAlreadySuspended          = errors.New("VM already suspended")
+	ErrVMNotResumed                = errors.New("VM not resumed")
+)
+
+const (
+	ControlStateStarting      = "starting"
+	ControlStateMigratingFrom = "migrating-from"
+	ControlStateResuming      = "resuming"
+	ControlStateResumed       = "resumed"
+	ControlStateMigratingTo   = "migrating-to"
+	ControlStateSuspending    = "suspending"
+	ControlStateSuspended     = "suspended"
+)
+
+type ControlMigrationProgress struct {
+	DeviceID uint32 `json:"deviceID"`
+	Remote   bool   `json:"remote"`
+
+	ReadyBlocks int `json:"readyBlocks"`
+	TotalBlocks int `json:"totalBlocks"`
+	DirtyBlocks int `json:"dirtyBlocks"` // Number of dirty blocks that were migrated after the initial blocks
+
+	AuthoritySent bool `json:"authoritySent"`
+	Completed     bool `json:"completed"`
+}
+
+type ControlStatus struct {
+	State      string    `json:"state"`
+	StateSince time.Time `json:"stateSince"`
+
+	VMPath string `json:"vmPath"`
+	VMPid  int    `json:"vmPid"`
+
+	MigrationAddr     string                     `json:"migrationAddr"`
+	MigrationPriority int                        `json:"migrationPriority"`
+	MigrationProgress []ControlMigrationProgress `json:"migrationProgress"`
+}
+
+type ControlDevice struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+
+	Remote         bool `json:"remote"`
+	MakeMigratable bool `json:"makeMigratable"`
+	Shared         bool `json:"shared"`
+}
+
+type ControlAddedDevice struct {
+	Name string `json:"name"`
+
+	Base    string `json:"base"`
+	Overlay string `json:"overlay"`
+	State   string `json:"state"`
+
+	BlockSize uint32 `json:"blockSize"`
+
+	Expiry time.Duration `json:"expiry"`
+
+	MaxDirtyBlocks int `json:"maxDirtyBlocks"`
+	MinCycles      int `json:"minCycles"`
+	MaxCycles      int `json:"maxCycles"`
+
+	CycleThrottle time.Duration `json:"cycleThrottle"`
+}
+
+type ControlLogs struct {
+	Lines []string `json:"lines"`
+	Next  int64    `json:"next"`
+}
+
+type ControlServerHandlers struct {
+	Status      func(ctx context.Context) (ControlStatus, error)
+	Devices     func(ctx context.Context) ([]ControlDevice, error)
+	Checkpoint  func(ctx context.Context) error
+	Migrate     func(ctx context.Context, raddr string, priority int) error
+	SetPriority func(ctx context.Context, priority int) error
+	AddDevice   func(ctx context.Context, device ControlAddedDevice) (ControlDevice, error)
+	Cancel      func(ctx context.Context) error
+	Logs        func(ctx context.Context, since int64, lines int) (ControlLogs, error)
+}
+
+// The RPCs the control client can call on this server
+// See https://github.com/pojntfx/panrpc/tree/main?tab=readme-ov-file#5-calling-the-clients-rpcs-from-the-server
+type ControlServerLocal struct {
+	handlers ControlServerHandlers
+}
+
+// The RPCs this server can call on the control client
+// See https://github.com/pojntfx/panrpc/tree/main?tab=readme-ov-file#4-calling-the-servers-rpcs-from-the-client
+type ControlServerRemote struct{}
+
+func NewControlServer(handlers ControlServerHandlers) *ControlServerLocal {
+	return &ControlServerLocal{
+		handlers: handlers,
+	}
+}
+
+func (l *ControlServerLocal) Status(ctx context.Context) (ControlStatus, error) {
+	if l.handlers.Status == nil {
+		return ControlStatus{}, ErrControlCommandNotSupported
+	}
+
+	return l.handlers.Status(ctx)
+}
+
+func (l *ControlServerLocal) Devices(ctx context.Context) ([]ControlDevice, error) {
+	if l.handlers.Devices == nil {
+		return nil, ErrControlCommandNotSupported
+	}
+
+	return l.handlers.Devices(ctx)
+}
+
+func (l *ControlServerLocal) Checkpoint(ctx context.Context) error {
+	if l.handlers.Checkpoint == nil {
+		return ErrControlCommandNotSupported
+	}
+
+	return l.handlers.Checkpoint(ctx)
+}
+
+func (l *ControlServerLocal) Migrate(ctx context.Context, raddr string, priority int) error {
+	if l.handlers.Migrate == nil {
+		return ErrControlCommandNotSupported
+	}
+
+	return l.handlers.Migrate(ctx, raddr, priority)
+}
+
+func (l *ControlServerLocal) SetPriority(ctx context.Context, priority int) error {
+	if l.handlers.SetPriority == nil {
+		return ErrControlCommandNotSupported
+	}
+
+	return l.handlers.SetPriority(ctx, priority)
+}
+
+func (l *ControlServerLocal) AddDevice(ctx context.Context, device ControlAddedDevice) (ControlDevice, error) {
+	if l.handlers.AddDevice == nil {
+		return ControlDevice{}, ErrControlCommandNotSupported
+	}
+
+	return l.handlers.AddDevice(ctx, device)
+}
+
+func (l *ControlServerLocal) Cancel(ctx context.Context) error {
+	if l.handlers.Cancel == nil {
+		return ErrControlCommandNotSupported
+	}
+
+	return l.handlers.Cancel(ctx)
+}
+
+func (l *ControlServerLocal) Logs(ctx context.Context, since int64, lines int) (ControlLogs, error) {
+	if l.handlers.Logs == nil {
+		return ControlLogs{}, ErrControlCommandNotSupported
+	}
+
+	return l.handlers.Logs(ctx, since, lines)
+}
+
+// ServeControl accepts control clients on `lis` until `ctx` is cancelled; the listener is closed on return
+func ServeControl(
+	ctx context.Context,
+
+	lis net.Listener,
+	controlServerLocal *ControlServerLocal,
+) (errs error) {
+	goroutineManager := manager.NewGoroutineManager(
+		ctx,
+		&errs,
+		manager.GoroutineManagerHooks{},
+	)
+	defer goroutineManager.Wait()
+	defer goroutineManager.StopAllGoroutines()
+	defer goroutineManager.CreateBackgroundPanicCollector()()
+
+	// This goroutine will not leak on function return because it selects on `goroutineManager.Context().Done()` internally
+	goroutineManager.StartForegroundGoroutine(func(ctx context.Context) {
+		<-ctx.Done()
+
+		_ = lis.Close() // We ignore errors here since we might interrupt a network connection
+	})
+
+	registry := rpc.NewRegistry[ControlServerRemote, cbor.RawMessage](
+		controlServerLocal,
+
+		&rpc.RegistryHooks{},
+	)
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			// Don't treat closed errors as errors if we closed the listener
+			if goroutineManager.Context().Err() != nil && errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			panic(errors.Join(ErrCouldNotAcceptControlClient, err))
+		}
+
+		// We don't track this because control clients can disconnect at any time without affecting the server
+		goroutineManager.StartBackgroundGoroutine(func(ctx context.Context) {
+			defer conn.Close()
+
+			encoder := cbor.NewEncoder(conn)
+			decoder := cbor.NewDecoder(conn)
+
+			// We ignore errors here since a control client disconnecting isn't an error for the server
+			_ = registry.LinkStream(
+				ctx,
+
+				func(v rpc.Message[cbor.RawMessage]) error {
+					return encoder.Encode(v)
+				},
+				func(v *rpc.Message[cbor.RawMessage]) error {
+					return decoder.Decode(v)
+				},
+
+				func(v any) (cbor.RawMessage, error) {
+					b, err := cbor.Marshal(v)
+					if err != nil {
+						return nil, errors.Join(ErrCouldNotMarshalJSON, err)
+					}
+
+					return cbor.RawMessage(b), nil
+				},
+				func(data cbor.RawMessage, v any) error {
+					if err := cbor.Unmarshal([]byte(data), v); err != nil {
+						return errors.Join(ErrCouldNotUnmarshalJSON, err)
+					}
+
+					return nil
+				},
+
+				nil,
+			)
+		})
+	}
+}
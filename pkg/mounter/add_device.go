@@ -0,0 +1,189 @@
+package mounter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/loopholelabs/silo/pkg/storage"
+	"github.com/loopholelabs/silo/pkg/storage/config"
+	"github.com/loopholelabs/silo/pkg/storage/device"
+)
+
+type MigrateToAddedDevice struct {
+	Name string `json:"name"`
+
+	Base    string `json:"base"`
+	Overlay string `json:"overlay"`
+	State   string `json:"state"`
+
+	BlockSize uint32 `json:"blockSize"`
+
+	Expiry time.Duration `json:"expiry"`
+
+	MaxDirtyBlocks int `json:"maxDirtyBlocks"`
+	MinCycles      int `json:"minCycles"`
+	MaxCycles      int `json:"maxCycles"`
+
+	CycleThrottle time.Duration `json:"cycleThrottle"`
+}
+
+// IsValidDeviceName checks if a device name can be used as a file name in a directory without escaping it,
+// which is required for names that are chosen by a remote
+func IsValidDeviceName(name string) bool {
+	return strings.TrimSpace(name) != "" && name != "." && name != ".." && filepath.Base(name) == name
+}
+
+// AddDevice adds a device, i.e. a drive that was hot-attached, to an ongoing `MigrateTo` session.
+// The device is announced to the destination after `registry.EventCustomAllDevicesSent`; devices
+// can only be added until the session starts transferring authority for its devices. Once added,
+// the device belongs to the migrated mounter, so it is included in later migrations and only
+// closed together with the mounter.
+func (migratableMounter *MigratableMounter) AddDevice(
+	device MigrateToAddedDevice,
+) (*MigratedDevice, uint32, error) {
+	migratableMounter.addDeviceLock.Lock()
+	defer migratableMounter.addDeviceLock.Unlock()
+
+	if migratableMounter.addDevice == nil {
+		return nil, 0, ErrNoActiveMigration
+	}
+
+	if !IsValidDeviceName(device.Name) {
+		return nil, 0, ErrInvalidDeviceName
+	}
+
+	migratedMounter := migratableMounter.migratedMounter
+	if !migratedMounter.reserveDeviceName(device.Name) {
+		return nil, 0, ErrDeviceAlreadyExists
+	}
+
+	local, dev, deferFuncs, err := CreateLocalDevice(device)
+	if err != nil {
+		// Release the name again so that adding the device can be retried
+		migratedMounter.releaseDeviceName(device.Name)
+
+		return nil, 0, errors.Join(ErrCouldNotAddDevice, err)
+	}
+
+	stage2Input := migrateFromAndMountStage{
+		name: device.Name,
+
+		blockSize: device.BlockSize,
+
+		remote: false,
+
+		storage: local,
+		device:  dev,
+	}
+
+	stage4Input, unlock := newMakeMigratableDeviceStage(makeMigratableFilterStage{
+		prev: stage2Input,
+
+		makeMigratableDevice: MakeMigratableDevice{
+			Name: device.Name,
+
+			Expiry: device.Expiry,
+		},
+	})
+
+	deviceID, err := migratableMounter.addDevice(stage4Input, MigrateToDevice{
+		Name: device.Name,
+
+		MaxDirtyBlocks: device.MaxDirtyBlocks,
+		MinCycles:      device.MinCycles,
+		MaxCycles:      device.MaxCycles,
+
+		CycleThrottle: device.CycleThrottle,
+	})
+	if err != nil {
+		err = errors.Join(ErrCouldNotAddDevice, err)
+
+		// Close the device again and release its name so that adding the device can be retried
+		_ = unlock() // We can safely ignore errors here since unlocking never fails
+		for _, deferFunc := range slices.Backward(deferFuncs) {
+			if closeErr := deferFunc(); closeErr != nil {
+				err = errors.Join(err, closeErr)
+			}
+		}
+		migratedMounter.releaseDeviceName(device.Name)
+
+		return nil, 0, err
+	}
+
+	// The storage is only locked for this migration, but the device itself outlives it
+	migratableMounter.addedDeviceDefersLock.Lock()
+	migratableMounter.addedDeviceDefers = append(migratableMounter.addedDeviceDefers, unlock)
+	migratableMounter.addedDeviceDefersLock.Unlock()
+
+	migratableMounter.stage4Inputs = append(migratableMounter.stage4Inputs, stage4Input)
+
+	migratedMounter.addedDeviceCloseFuncsLock.Lock()
+	migratedMounter.addedDeviceCloseFuncs = append(migratedMounter.addedDeviceCloseFuncs, deferFuncs...)
+	migratedMounter.addedDeviceCloseFuncsLock.Unlock()
+
+	migratedMounter.stage2InputsLock.Lock()
+	migratedMounter.stage2Inputs = append(migratedMounter.stage2Inputs, stage2Input)
+	migratedMounter.stage2InputsLock.Unlock()
+
+	return &MigratedDevice{
+		Name: device.Name,
+		Path: filepath.Join("/dev", dev.Device()),
+	}, deviceID, nil
+}
+
+// CreateLocalDevice creates and exposes a Silo device for a local base file, or for a sparse overlay on top of it if both
+// an overlay and a state file are set; the returned functions close the device again and have to be called in reverse order.
+// If creating the device fails, nothing needs to be closed.
+func CreateLocalDevice(input MigrateToAddedDevice) (local storage.Provider, dev storage.ExposedStorage, deferFuncs []func() error, err error) {
+	stat, err := os.Stat(input.Base)
+	if err != nil {
+		return nil, nil, deferFuncs, errors.Join(ErrCouldNotGetBaseDeviceStat, err)
+	}
+
+	if strings.TrimSpace(input.Overlay) == "" || strings.TrimSpace(input.State) == "" {
+		local, dev, err = device.NewDevice(&config.DeviceSchema{
+			Name:      input.Name,
+			System:    "file",
+			Location:  input.Base,
+			Size:      fmt.Sprintf("%v", stat.Size()),
+			BlockSize: fmt.Sprintf("%v", input.BlockSize),
+			Expose:    true,
+		})
+	} else {
+		if err := os.MkdirAll(filepath.Dir(input.Overlay), os.ModePerm); err != nil {
+			return nil, nil, deferFuncs, errors.Join(ErrCouldNotCreateOverlayDirectory, err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(input.State), os.ModePerm); err != nil {
+			return nil, nil, deferFuncs, errors.Join(ErrCouldNotCreateStateDirectory, err)
+		}
+
+		local, dev, err = device.NewDevice(&config.DeviceSchema{
+			Name:      input.Name,
+			System:    "sparsefile",
+			Location:  input.Overlay,
+			Size:      fmt.Sprintf("%v", stat.Size()),
+			BlockSize: fmt.Sprintf("%v", input.BlockSize),
+			Expose:    true,
+			ROSource: &config.DeviceSchema{
+				Name:     input.State,
+				System:   "file",
+				Location: input.Base,
+				Size:     fmt.Sprintf("%v", stat.Size()),
+			},
+		})
+	}
+	if err != nil {
+		return nil, nil, deferFuncs, errors.Join(ErrCouldNotCreateLocalDevice, err)
+	}
+	deferFuncs = append(deferFuncs, local.Close, dev.Shutdown)
+
+	dev.SetProvider(local)
+
+	return local, dev, deferFuncs, nil
+}
@@ -27,4 +27,10 @@ var (
 	ErrCouldNotSendTransferAuthorityEvent = errors.New("could not send transfer authority event")
 	ErrCouldNotSendCompletedEvent         = errors.New("could not send completed event")
 	ErrCouldNotMigrateToDevice            = errors.New("could not migrate to device")
+	ErrNoActiveMigration                  = errors.New("no active migration")
+	ErrDeviceAlreadyExists                = errors.New("device already exists")
+	ErrCouldNotAddDevice                  = errors.New("could not add device")
+	ErrAuthorityTransferAlreadyStarted    = errors.New("authority transfer already started")
+	ErrInvalidDeviceName                  = errors.New("invalid device name")
+	ErrNoInitialDevices                   = errors.New("no initial devices to announce added devices after")
 )
@@ -3,10 +3,13 @@ package mounter
 import (
 	"context"
 	"errors"
+	"slices"
+	"sync"
 	"time"
 
 	iutils "github.com/loopholelabs/drafter/internal/utils"
 	"github.com/loopholelabs/goroutine-manager/pkg/manager"
+	"github.com/loopholelabs/silo/pkg/storage"
 	"github.com/loopholelabs/silo/pkg/storage/blocks"
 	"github.com/loopholelabs/silo/pkg/storage/dirtytracker"
 	"github.com/loopholelabs/silo/pkg/storage/modules"
@@ -30,7 +33,90 @@ type MigratedMounter struct {
 	Wait  func() error
 	Close func() error
 
-	stage2Inputs []migrateFromAndMountStage
+	stage2InputsLock    sync.Mutex
+	stage2Inputs        []migrateFromAndMountStage
+	reservedDeviceNames map[string]struct{}
+
+	addedDeviceCloseFuncsLock sync.Mutex
+	addedDeviceCloseFuncs     []func() error
+}
+
+// reserveDeviceName reserves `name` for a device that is being set up, but not yet in `stage2Inputs`;
+// it returns false if another device already uses or reserved the name
+func (migratedMounter *MigratedMounter) reserveDeviceName(name string) bool {
+	migratedMounter.stage2InputsLock.Lock()
+	defer migratedMounter.stage2InputsLock.Unlock()
+
+	if _, ok := migratedMounter.reservedDeviceNames[name]; ok || slices.ContainsFunc(
+		migratedMounter.stage2Inputs,
+		func(r migrateFromAndMountStage) bool {
+			return name == r.name
+		},
+	) {
+		return false
+	}
+
+	if migratedMounter.reservedDeviceNames == nil {
+		migratedMounter.reservedDeviceNames = map[string]struct{}{}
+	}
+	migratedMounter.reservedDeviceNames[name] = struct{}{}
+
+	return true
+}
+
+func (migratedMounter *MigratedMounter) releaseDeviceName(name string) {
+	migratedMounter.stage2InputsLock.Lock()
+	defer migratedMounter.stage2InputsLock.Unlock()
+
+	delete(migratedMounter.reservedDeviceNames, name)
+}
+
+// MigratableStorage is a device's storage wrapped so that it can be migrated while it is being used
+type MigratableStorage struct {
+	Storage     *modules.Lockable
+	Orderer     *blocks.PriorityBlockOrder
+	TotalBlocks int
+	DirtyRemote *dirtytracker.Remote
+}
+
+// NewMigratableStorage tracks the dirty and volatile blocks of `local` and exposes the resulting storage through `dev`;
+// the returned function unlocks the storage again, i.e. after a migration was cancelled while it was locked
+func NewMigratableStorage(local storage.Provider, dev storage.ExposedStorage, blockSize uint32, expiry time.Duration) (MigratableStorage, func() error) {
+	dirtyLocal, dirtyRemote := dirtytracker.NewDirtyTracker(local, int(blockSize))
+	monitor := volatilitymonitor.NewVolatilityMonitor(dirtyLocal, int(blockSize), expiry)
+
+	lockable := modules.NewLockable(monitor)
+
+	dev.SetProvider(lockable)
+
+	totalBlocks := (int(lockable.Size()) + int(blockSize) - 1) / int(blockSize)
+
+	orderer := blocks.NewPriorityBlockOrder(totalBlocks, monitor)
+	orderer.AddAll()
+
+	return MigratableStorage{
+		Storage:     lockable,
+		Orderer:     orderer,
+		TotalBlocks: totalBlocks,
+		DirtyRemote: dirtyRemote,
+	}, func() error {
+		lockable.Unlock()
+
+		return nil
+	}
+}
+
+func newMakeMigratableDeviceStage(input makeMigratableFilterStage) (makeMigratableDeviceStage, func() error) {
+	migratableStorage, unlock := NewMigratableStorage(input.prev.storage, input.prev.device, input.prev.blockSize, input.makeMigratableDevice.Expiry)
+
+	return makeMigratableDeviceStage{
+		prev: input,
+
+		storage:     migratableStorage.Storage,
+		orderer:     migratableStorage.Orderer,
+		totalBlocks: migratableStorage.TotalBlocks,
+		dirtyRemote: migratableStorage.DirtyRemote,
+	}, unlock
 }
 
 func (migratedMounter *MigratedMounter) MakeMigratable(
@@ -40,6 +126,8 @@ func (migratedMounter *MigratedMounter) MakeMigratable(
 ) (migratableMounter *MigratableMounter, errs error) {
 	migratableMounter = &MigratableMounter{
 		Close: func() {},
+
+		migratedMounter: migratedMounter,
 	}
 
 	goroutineManager := manager.NewGoroutineManager(
@@ -51,8 +139,13 @@ func (migratedMounter *MigratedMounter) MakeMigratable(
 	defer goroutineManager.StopAllGoroutines()
 	defer goroutineManager.CreateBackgroundPanicCollector()()
 
+	// Devices can still be added while we make the mounter migratable, so we need to copy the devices under the lock
+	migratedMounter.stage2InputsLock.Lock()
+	stage2Inputs := slices.Clone(migratedMounter.stage2Inputs)
+	migratedMounter.stage2InputsLock.Unlock()
+
 	stage3Inputs := []makeMigratableFilterStage{}
-	for _, input := range migratedMounter.stage2Inputs {
+	for _, input := range stage2Inputs {
 		var makeMigratableDevice *MakeMigratableDevice
 		for _, device := range devices {
 			if device.Name == input.name {
@@ -81,34 +174,23 @@ func (migratedMounter *MigratedMounter) MakeMigratable(
 	migratableMounter.stage4Inputs, deferFuncs, err = iutils.ConcurrentMap(
 		stage3Inputs,
 		func(index int, input makeMigratableFilterStage, output *makeMigratableDeviceStage, addDefer func(deferFunc func() error)) error {
-			output.prev = input
-
-			dirtyLocal, dirtyRemote := dirtytracker.NewDirtyTracker(input.prev.storage, int(input.prev.blockSize))
-			output.dirtyRemote = dirtyRemote
-			monitor := volatilitymonitor.NewVolatilityMonitor(dirtyLocal, int(input.prev.blockSize), input.makeMigratableDevice.Expiry)
-
-			local := modules.NewLockable(monitor)
-			output.storage = local
-			addDefer(func() error {
-				local.Unlock()
-
-				return nil
-			})
-
-			input.prev.device.SetProvider(local)
-
-			totalBlocks := (int(local.Size()) + int(input.prev.blockSize) - 1) / int(input.prev.blockSize)
-			output.totalBlocks = totalBlocks
-
-			orderer := blocks.NewPriorityBlockOrder(totalBlocks, monitor)
-			output.orderer = orderer
-			orderer.AddAll()
+			var unlock func() error
+			*output, unlock = newMakeMigratableDeviceStage(input)
+			addDefer(unlock)
 
 			return nil
 		},
 	)
 
 	migratableMounter.Close = func() {
+		// Devices that were added during a migration are unlocked after the devices that were made migratable here
+		migratableMounter.addedDeviceDefersLock.Lock()
+		defer migratableMounter.addedDeviceDefersLock.Unlock()
+
+		for _, deferFunc := range migratableMounter.addedDeviceDefers {
+			defer deferFunc() // We can safely ignore errors here since we never add a function that could return an error
+		}
+
 		// Make sure that we schedule the `deferFuncs` even if we get an error
 		for _, deferFuncs := range deferFuncs {
 			for _, deferFunc := range deferFuncs {
@@ -40,6 +40,15 @@ type MigrateFromHooks struct {
 	OnRemoteDeviceAuthorityReceived  func(remoteDeviceID uint32, customPayload []byte)
 	OnRemoteDeviceMigrationCompleted func(remoteDeviceID uint32)
 
+	// This is called when the source sends a device that isn't
+	// configured locally, i.e. because it was added after the
+	// registry.EventCustomAllDevicesSent event; the name has
+	// been checked with IsValidDeviceName and the return value
+	// will be used as the base path for the device. If this
+	// isn't set or returns an empty string, the migration
+	// fails with terminator.ErrUnknownDeviceName
+	OnRemoteDeviceAdded func(remoteDeviceID uint32, name string) string
+
 	OnRemoteAllDevicesReceived     func()
 	OnRemoteAllMigrationsCompleted func()
 
@@ -113,8 +122,6 @@ func MigrateFromAndMount(
 		deviceCloseFuncsLock sync.Mutex
 		deviceCloseFuncs     []func() error
 
-		stage2InputsLock sync.Mutex
-
 		pro *protocol.RW
 	)
 	if len(readers) > 0 && len(writers) > 0 { // Only open the protocol if we want passed in readers and writers
@@ -133,15 +140,38 @@ func MigrateFromAndMount(
 					func(di *packets.DevInfo) storage.Provider {
 						// No need to `defer goroutineManager.HandlePanics` here - panics bubble upwards
 
-						base := ""
+						// We reserve the name in the same critical section as the check so that concurrent device infos can't both pass it
+						if !migratedMounter.reserveDeviceName(di.Name) {
+							panic(ErrDeviceAlreadyExists)
+						}
+
+						var (
+							base       = ""
+							configured = false
+						)
 						for _, device := range devices {
 							if di.Name == device.Name {
 								base = device.Base
+								configured = true
 
 								break
 							}
 						}
 
+						// This device isn't configured locally, i.e. because it was hot-attached on the source and announced after
+						// `EventCustomAllDevicesSent`, so we ask the hook where to store it. We can't use `allRemoteDevicesReceived` to
+						// detect this since events are handled concurrently with device infos.
+						if !configured {
+							// The name is chosen by the remote, so make sure that it can't escape the directory we store it in
+							if !IsValidDeviceName(di.Name) {
+								panic(ErrInvalidDeviceName)
+							}
+
+							if hook := hooks.OnRemoteDeviceAdded; hook != nil {
+								base = hook(index, di.Name)
+							}
+						}
+
 						if strings.TrimSpace(base) == "" {
 							panic(terminator.ErrUnknownDeviceName)
 						}
@@ -202,7 +232,7 @@ func MigrateFromAndMount(
 
 						device.SetProvider(local)
 
-						stage2InputsLock.Lock()
+						migratedMounter.stage2InputsLock.Lock()
 						migratedMounter.stage2Inputs = append(migratedMounter.stage2Inputs, migrateFromAndMountStage{
 							name: di.Name,
 
@@ -214,7 +244,7 @@ func MigrateFromAndMount(
 							storage: local,
 							device:  device,
 						})
-						stage2InputsLock.Unlock()
+						migratedMounter.stage2InputsLock.Unlock()
 
 						devicePath := filepath.Join("/dev", device.Device())
 
@@ -340,6 +370,18 @@ func MigrateFromAndMount(
 			}(closeFunc)
 		}
 
+		// Devices that were added while migrating the mounter onwards are closed before the devices that were migrated here
+		migratedMounter.addedDeviceCloseFuncsLock.Lock()
+		defer migratedMounter.addedDeviceCloseFuncsLock.Unlock()
+
+		for _, closeFunc := range migratedMounter.addedDeviceCloseFuncs {
+			defer func(closeFunc func() error) {
+				if err := closeFunc(); err != nil {
+					errs = errors.Join(errs, err)
+				}
+			}(closeFunc)
+		}
+
 		return
 	}
 
@@ -384,12 +426,16 @@ func MigrateFromAndMount(
 
 	stage1Inputs := []MigrateFromAndMountDevice{}
 	for _, input := range devices {
-		if slices.ContainsFunc(
+		migratedMounter.stage2InputsLock.Lock()
+		received := slices.ContainsFunc(
 			migratedMounter.stage2Inputs,
 			func(r migrateFromAndMountStage) bool {
 				return input.Name == r.name
 			},
-		) {
+		)
+		migratedMounter.stage2InputsLock.Unlock()
+
+		if received {
 			continue
 		}
 
@@ -463,7 +509,7 @@ func MigrateFromAndMount(
 
 			dev.SetProvider(local)
 
-			stage2InputsLock.Lock()
+			migratedMounter.stage2InputsLock.Lock()
 			migratedMounter.stage2Inputs = append(migratedMounter.stage2Inputs, migrateFromAndMountStage{
 				name: input.Name,
 
@@ -475,7 +521,7 @@ func MigrateFromAndMount(
 				storage: local,
 				device:  dev,
 			})
-			stage2InputsLock.Unlock()
+			migratedMounter.stage2InputsLock.Unlock()
 
 			devicePath := filepath.Join("/dev", dev.Device())
 
@@ -511,6 +557,9 @@ func MigrateFromAndMount(
 		break
 	}
 
+	migratedMounter.stage2InputsLock.Lock()
+	defer migratedMounter.stage2InputsLock.Unlock()
+
 	for _, input := range migratedMounter.stage2Inputs {
 		migratedMounter.Devices = append(migratedMounter.Devices, MigratedDevice{
 			Name: input.name,
@@ -50,7 +50,21 @@ type MigrateToDevice struct {
 type MigratableMounter struct {
 	Close func()
 
-	stage4Inputs []makeMigratableDeviceStage
+	migratedMounter *MigratedMounter
+	stage4Inputs    []makeMigratableDeviceStage
+
+	addDeviceLock sync.Mutex
+	addDevice     func(input makeMigratableDeviceStage, migrateToDevice MigrateToDevice) (uint32, error)
+
+	addedDeviceDefersLock sync.Mutex
+	addedDeviceDefers     []func() error
+}
+
+func (migratableMounter *MigratableMounter) setAddDevice(addDevice func(input makeMigratableDeviceStage, migrateToDevice MigrateToDevice) (uint32, error)) {
+	migratableMounter.addDeviceLock.Lock()
+	defer migratableMounter.addDeviceLock.Unlock()
+
+	migratableMounter.addDevice = addDevice
 }
 
 func (migratableMounter *MigratableMounter) MigrateTo(
@@ -130,21 +144,62 @@ func (migratableMounter *MigratableMounter) MigrateTo(
 		})
 	}
 
-	_, deferFuncs, err := iutils.ConcurrentMap(
-		stage5Inputs,
-		func(index int, input migrateToStage, _ *struct{}, _ func(deferFunc func() error)) error {
-			to := protocol.NewToProtocol(input.prev.storage.Size(), uint32(index), pro)
+	var (
+		totalDevices    atomic.Int32
+		nextDeviceIndex atomic.Int32
 
-			if err := to.SendDevInfo(input.prev.prev.prev.name, input.prev.prev.prev.blockSize, ""); err != nil {
-				return errors.Join(ErrCouldNotSendDevInfo, err)
-			}
+		addedDevicesLock     sync.Mutex
+		addedDevicesAccepted = len(stage5Inputs) > 0 // If there are no initial devices, `EventCustomAllDevicesSent` is never sent, so we can't announce added devices
+		addedDevicesWg       sync.WaitGroup
+	)
 
-			if hook := hooks.OnDeviceSent; hook != nil {
-				hook(uint32(index), input.prev.prev.prev.remote)
+	// Added devices must only be announced after `EventCustomAllDevicesSent` has been sent
+	allDevicesSentCh := make(chan struct{})
+	totalDevices.Store(int32(len(stage5Inputs)))
+	nextDeviceIndex.Store(int32(len(stage5Inputs)))
+
+	allDevicesReadyForAuthorityTransfer := func() bool {
+		addedDevicesLock.Lock()
+		defer addedDevicesLock.Unlock()
+
+		if devicesLeftToTransferAuthorityFor.Load() < totalDevices.Load() {
+			return false
+		}
+
+		// Once we start transferring authority, the destination can't accept any additional devices
+		addedDevicesAccepted = false
+
+		return true
+	}
+
+	migrateDevice := func(index int, input migrateToStage, initial bool) error {
+		if !initial {
+			select {
+			case <-allDevicesSentCh:
+				break
+
+			case <-goroutineManager.Context().Done():
+				if err := goroutineManager.Context().Err(); err != nil {
+					return errors.Join(ErrMounterContextCancelled, err)
+				}
+
+				return nil
 			}
+		}
+
+		to := protocol.NewToProtocol(input.prev.storage.Size(), uint32(index), pro)
+
+		if err := to.SendDevInfo(input.prev.prev.prev.name, input.prev.prev.prev.blockSize, ""); err != nil {
+			return errors.Join(ErrCouldNotSendDevInfo, err)
+		}
 
-			devicesLeftToSend.Add(1)
-			if devicesLeftToSend.Load() >= int32(len(stage5Inputs)) {
+		if hook := hooks.OnDeviceSent; hook != nil {
+			hook(uint32(index), input.prev.prev.prev.remote)
+		}
+
+		// Devices that were added after the initial set of devices are announced after `EventCustomAllDevicesSent`
+		if initial {
+			if devicesLeftToSend.Add(1) >= int32(len(stage5Inputs)) {
 				goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
 					if err := to.SendEvent(&packets.Event{
 						Type:       packets.EventCustom,
@@ -153,250 +208,291 @@ func (migratableMounter *MigratableMounter) MigrateTo(
 						panic(errors.Join(ErrCouldNotSendAllDevicesSentEvent, err))
 					}
 
+					close(allDevicesSentCh) // We can safely close() this channel since this goroutine is only started once, by the last initial device
+
 					if hook := hooks.OnAllDevicesSent; hook != nil {
 						hook()
 					}
 				})
 			}
+		}
 
-			goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
-				if err := to.HandleNeedAt(func(offset int64, length int32) {
-					// Prioritize blocks
-					endOffset := uint64(offset + int64(length))
-					if endOffset > uint64(input.prev.storage.Size()) {
-						endOffset = uint64(input.prev.storage.Size())
-					}
-
-					startBlock := int(offset / int64(input.prev.prev.prev.blockSize))
-					endBlock := int((endOffset-1)/uint64(input.prev.prev.prev.blockSize)) + 1
-					for b := startBlock; b < endBlock; b++ {
-						input.prev.orderer.PrioritiseBlock(b)
-					}
-				}); err != nil {
-					panic(errors.Join(registry.ErrCouldNotHandleNeedAt, err))
+		goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
+			if err := to.HandleNeedAt(func(offset int64, length int32) {
+				// Prioritize blocks
+				endOffset := uint64(offset + int64(length))
+				if endOffset > uint64(input.prev.storage.Size()) {
+					endOffset = uint64(input.prev.storage.Size())
 				}
-			})
-
-			goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
-				if err := to.HandleDontNeedAt(func(offset int64, length int32) {
-					// Deprioritize blocks
-					endOffset := uint64(offset + int64(length))
-					if endOffset > uint64(input.prev.storage.Size()) {
-						endOffset = uint64(input.prev.storage.Size())
-					}
 
-					startBlock := int(offset / int64(input.prev.storage.Size()))
-					endBlock := int((endOffset-1)/uint64(input.prev.storage.Size())) + 1
-					for b := startBlock; b < endBlock; b++ {
-						input.prev.orderer.Remove(b)
-					}
-				}); err != nil {
-					panic(errors.Join(registry.ErrCouldNotHandleDontNeedAt, err))
+				startBlock := int(offset / int64(input.prev.prev.prev.blockSize))
+				endBlock := int((endOffset-1)/uint64(input.prev.prev.prev.blockSize)) + 1
+				for b := startBlock; b < endBlock; b++ {
+					input.prev.orderer.PrioritiseBlock(b)
 				}
-			})
-
-			cfg := migrator.NewConfig().WithBlockSize(int(input.prev.prev.prev.blockSize))
-			cfg.Concurrency = map[int]int{
-				storage.BlockTypeAny:      concurrency,
-				storage.BlockTypeStandard: concurrency,
-				storage.BlockTypeDirty:    concurrency,
-				storage.BlockTypePriority: concurrency,
+			}); err != nil {
+				panic(errors.Join(registry.ErrCouldNotHandleNeedAt, err))
 			}
-			cfg.LockerHandler = func() {
-				defer goroutineManager.CreateBackgroundPanicCollector()()
+		})
 
-				if err := to.SendEvent(&packets.Event{
-					Type: packets.EventPreLock,
-				}); err != nil {
-					panic(errors.Join(ErrCouldNotSendPreLockEvent, err))
+		goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
+			if err := to.HandleDontNeedAt(func(offset int64, length int32) {
+				// Deprioritize blocks
+				endOffset := uint64(offset + int64(length))
+				if endOffset > uint64(input.prev.storage.Size()) {
+					endOffset = uint64(input.prev.storage.Size())
 				}
 
-				input.prev.storage.Lock()
-
-				if err := to.SendEvent(&packets.Event{
-					Type: packets.EventPostLock,
-				}); err != nil {
-					panic(errors.Join(ErrCouldNotSendPostLockEvent, err))
+				startBlock := int(offset / int64(input.prev.storage.Size()))
+				endBlock := int((endOffset-1)/uint64(input.prev.storage.Size())) + 1
+				for b := startBlock; b < endBlock; b++ {
+					input.prev.orderer.Remove(b)
 				}
+			}); err != nil {
+				panic(errors.Join(registry.ErrCouldNotHandleDontNeedAt, err))
 			}
-			cfg.UnlockerHandler = func() {
-				defer goroutineManager.CreateBackgroundPanicCollector()()
+		})
 
-				if err := to.SendEvent(&packets.Event{
-					Type: packets.EventPreUnlock,
-				}); err != nil {
-					panic(errors.Join(ErrCouldNotSendPreUnlockEvent, err))
-				}
+		cfg := migrator.NewConfig().WithBlockSize(int(input.prev.prev.prev.blockSize))
+		cfg.Concurrency = map[int]int{
+			storage.BlockTypeAny:      concurrency,
+			storage.BlockTypeStandard: concurrency,
+			storage.BlockTypeDirty:    concurrency,
+			storage.BlockTypePriority: concurrency,
+		}
+		cfg.LockerHandler = func() {
+			defer goroutineManager.CreateBackgroundPanicCollector()()
 
-				input.prev.storage.Unlock()
+			if err := to.SendEvent(&packets.Event{
+				Type: packets.EventPreLock,
+			}); err != nil {
+				panic(errors.Join(ErrCouldNotSendPreLockEvent, err))
+			}
 
-				if err := to.SendEvent(&packets.Event{
-					Type: packets.EventPostUnlock,
-				}); err != nil {
-					panic(errors.Join(ErrCouldNotSendPostUnlockEvent, err))
-				}
+			input.prev.storage.Lock()
+
+			if err := to.SendEvent(&packets.Event{
+				Type: packets.EventPostLock,
+			}); err != nil {
+				panic(errors.Join(ErrCouldNotSendPostLockEvent, err))
 			}
-			cfg.ErrorHandler = func(b *storage.BlockInfo, err error) {
-				defer goroutineManager.CreateBackgroundPanicCollector()()
+		}
+		cfg.UnlockerHandler = func() {
+			defer goroutineManager.CreateBackgroundPanicCollector()()
 
-				if err != nil {
-					panic(errors.Join(registry.ErrCouldNotContinueWithMigration, err))
-				}
+			if err := to.SendEvent(&packets.Event{
+				Type: packets.EventPreUnlock,
+			}); err != nil {
+				panic(errors.Join(ErrCouldNotSendPreUnlockEvent, err))
 			}
-			cfg.ProgressHandler = func(p *migrator.MigrationProgress) {
-				if hook := hooks.OnDeviceInitialMigrationProgress; hook != nil {
-					hook(uint32(index), input.prev.prev.prev.remote, p.ReadyBlocks, p.TotalBlocks)
-				}
+
+			input.prev.storage.Unlock()
+
+			if err := to.SendEvent(&packets.Event{
+				Type: packets.EventPostUnlock,
+			}); err != nil {
+				panic(errors.Join(ErrCouldNotSendPostUnlockEvent, err))
 			}
+		}
+		cfg.ErrorHandler = func(b *storage.BlockInfo, err error) {
+			defer goroutineManager.CreateBackgroundPanicCollector()()
 
-			mig, err := migrator.NewMigrator(input.prev.dirtyRemote, to, input.prev.orderer, cfg)
 			if err != nil {
-				return errors.Join(registry.ErrCouldNotCreateMigrator, err)
+				panic(errors.Join(registry.ErrCouldNotContinueWithMigration, err))
 			}
-
-			if err := mig.Migrate(input.prev.totalBlocks); err != nil {
-				return errors.Join(ErrCouldNotMigrateBlocks, err)
+		}
+		cfg.ProgressHandler = func(p *migrator.MigrationProgress) {
+			if hook := hooks.OnDeviceInitialMigrationProgress; hook != nil {
+				hook(uint32(index), input.prev.prev.prev.remote, p.ReadyBlocks, p.TotalBlocks)
 			}
+		}
 
-			if err := mig.WaitForCompletion(); err != nil {
-				return errors.Join(registry.ErrCouldNotWaitForMigrationCompletion, err)
-			}
+		mig, err := migrator.NewMigrator(input.prev.dirtyRemote, to, input.prev.orderer, cfg)
+		if err != nil {
+			return errors.Join(registry.ErrCouldNotCreateMigrator, err)
+		}
 
-			markDeviceAsReadyForAuthorityTransfer := sync.OnceFunc(func() {
-				devicesLeftToTransferAuthorityFor.Add(1)
-			})
+		if err := mig.Migrate(input.prev.totalBlocks); err != nil {
+			return errors.Join(ErrCouldNotMigrateBlocks, err)
+		}
 
-			var (
-				cyclesBelowDirtyBlockTreshold = 0
-				totalCycles                   = 0
-				ongoingMigrationsWg           sync.WaitGroup
-			)
-			for {
-				ongoingMigrationsWg.Wait()
-
-				if hook := hooks.OnBeforeGetDirtyBlocks; hook != nil {
-					hook(uint32(index), input.prev.prev.prev.remote)
-				}
+		if err := mig.WaitForCompletion(); err != nil {
+			return errors.Join(registry.ErrCouldNotWaitForMigrationCompletion, err)
+		}
 
-				blocks := mig.GetLatestDirty()
-				if blocks == nil {
-					mig.Unlock()
+		markDeviceAsReadyForAuthorityTransfer := sync.OnceFunc(func() {
+			devicesLeftToTransferAuthorityFor.Add(1)
+		})
 
-					suspendedVMLock.Lock()
-					if suspendedVM {
-						suspendedVMLock.Unlock()
+		var (
+			cyclesBelowDirtyBlockTreshold = 0
+			totalCycles                   = 0
+			ongoingMigrationsWg           sync.WaitGroup
+		)
+		for {
+			ongoingMigrationsWg.Wait()
 
-						break
-					}
+			if hook := hooks.OnBeforeGetDirtyBlocks; hook != nil {
+				hook(uint32(index), input.prev.prev.prev.remote)
+			}
+
+			blocks := mig.GetLatestDirty()
+			if blocks == nil {
+				mig.Unlock()
+
+				suspendedVMLock.Lock()
+				if suspendedVM {
 					suspendedVMLock.Unlock()
+
+					break
 				}
+				suspendedVMLock.Unlock()
+			}
 
-				if blocks != nil {
-					if err := to.DirtyList(int(input.prev.prev.prev.blockSize), blocks); err != nil {
-						return errors.Join(ErrCouldNotSendDirtyList, err)
+			if blocks != nil {
+				if err := to.DirtyList(int(input.prev.prev.prev.blockSize), blocks); err != nil {
+					return errors.Join(ErrCouldNotSendDirtyList, err)
+				}
+
+				ongoingMigrationsWg.Add(1)
+				goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
+					defer ongoingMigrationsWg.Done()
+
+					if err := mig.MigrateDirty(blocks); err != nil {
+						panic(errors.Join(ErrCouldNotMigrateDirtyBlocks, err))
 					}
 
-					ongoingMigrationsWg.Add(1)
-					goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
-						defer ongoingMigrationsWg.Done()
+					suspendedVMLock.Lock()
+					defer suspendedVMLock.Unlock()
 
-						if err := mig.MigrateDirty(blocks); err != nil {
-							panic(errors.Join(ErrCouldNotMigrateDirtyBlocks, err))
+					if suspendedVM {
+						if hook := hooks.OnDeviceFinalMigrationProgress; hook != nil {
+							hook(uint32(index), input.prev.prev.prev.remote, len(blocks))
 						}
-
-						suspendedVMLock.Lock()
-						defer suspendedVMLock.Unlock()
-
-						if suspendedVM {
-							if hook := hooks.OnDeviceFinalMigrationProgress; hook != nil {
-								hook(uint32(index), input.prev.prev.prev.remote, len(blocks))
-							}
-						} else {
-							if hook := hooks.OnDeviceContinousMigrationProgress; hook != nil {
-								hook(uint32(index), input.prev.prev.prev.remote, len(blocks))
-							}
+					} else {
+						if hook := hooks.OnDeviceContinousMigrationProgress; hook != nil {
+							hook(uint32(index), input.prev.prev.prev.remote, len(blocks))
 						}
-					})
-				}
-
-				suspendedVMLock.Lock()
-				if !suspendedVM && !(devicesLeftToTransferAuthorityFor.Load() >= int32(len(stage5Inputs))) {
-					suspendedVMLock.Unlock()
+					}
+				})
+			}
 
-					// We use the background context here instead of the internal context because we want to distinguish
-					// between a context cancellation from the outside and getting a response
-					cycleThrottleCtx, cancelCycleThrottleCtx := context.WithTimeout(context.Background(), input.migrateToDevice.CycleThrottle)
-					defer cancelCycleThrottleCtx()
+			suspendedVMLock.Lock()
+			if !suspendedVM && !(devicesLeftToTransferAuthorityFor.Load() >= totalDevices.Load()) {
+				suspendedVMLock.Unlock()
 
-					select {
-					case <-cycleThrottleCtx.Done():
-						break
+				// We use the background context here instead of the internal context because we want to distinguish
+				// between a context cancellation from the outside and getting a response
+				cycleThrottleCtx, cancelCycleThrottleCtx := context.WithTimeout(context.Background(), input.migrateToDevice.CycleThrottle)
+				defer cancelCycleThrottleCtx()
 
-					case <-suspendedVMCh:
-						break
+				select {
+				case <-cycleThrottleCtx.Done():
+					break
 
-					case <-goroutineManager.Context().Done(): // ctx is the goroutineManager.Context() here
-						if err := goroutineManager.Context().Err(); err != nil {
-							return errors.Join(ErrMounterContextCancelled, err)
-						}
+				case <-suspendedVMCh:
+					break
 
-						return nil
+				case <-goroutineManager.Context().Done(): // ctx is the goroutineManager.Context() here
+					if err := goroutineManager.Context().Err(); err != nil {
+						return errors.Join(ErrMounterContextCancelled, err)
 					}
-				} else {
-					suspendedVMLock.Unlock()
+
+					return nil
 				}
+			} else {
+				suspendedVMLock.Unlock()
+			}
 
-				totalCycles++
-				if len(blocks) < input.migrateToDevice.MaxDirtyBlocks {
-					cyclesBelowDirtyBlockTreshold++
-					if cyclesBelowDirtyBlockTreshold > input.migrateToDevice.MinCycles {
-						markDeviceAsReadyForAuthorityTransfer()
-					}
-				} else if totalCycles > input.migrateToDevice.MaxCycles {
+			totalCycles++
+			if len(blocks) < input.migrateToDevice.MaxDirtyBlocks {
+				cyclesBelowDirtyBlockTreshold++
+				if cyclesBelowDirtyBlockTreshold > input.migrateToDevice.MinCycles {
 					markDeviceAsReadyForAuthorityTransfer()
-				} else {
-					cyclesBelowDirtyBlockTreshold = 0
 				}
+			} else if totalCycles > input.migrateToDevice.MaxCycles {
+				markDeviceAsReadyForAuthorityTransfer()
+			} else {
+				cyclesBelowDirtyBlockTreshold = 0
+			}
 
-				if devicesLeftToTransferAuthorityFor.Load() >= int32(len(stage5Inputs)) {
-					if err := suspendAndMsyncVM(); err != nil {
-						return errors.Join(ErrCouldNotSuspendAndMsyncVM, err)
-					}
+			if allDevicesReadyForAuthorityTransfer() {
+				if err := suspendAndMsyncVM(); err != nil {
+					return errors.Join(ErrCouldNotSuspendAndMsyncVM, err)
 				}
 			}
+		}
 
-			var customPayload []byte
-			if hook := hooks.OnBeforeSendDeviceAuthority; hook != nil {
-				customPayload = hook(uint32(index), input.prev.prev.prev.remote)
-			}
+		var customPayload []byte
+		if hook := hooks.OnBeforeSendDeviceAuthority; hook != nil {
+			customPayload = hook(uint32(index), input.prev.prev.prev.remote)
+		}
 
-			if err := to.SendEvent(&packets.Event{
-				Type:          packets.EventCustom,
-				CustomType:    byte(registry.EventCustomTransferAuthority),
-				CustomPayload: customPayload,
-			}); err != nil {
-				panic(errors.Join(ErrCouldNotSendTransferAuthorityEvent, err))
-			}
+		if err := to.SendEvent(&packets.Event{
+			Type:          packets.EventCustom,
+			CustomType:    byte(registry.EventCustomTransferAuthority),
+			CustomPayload: customPayload,
+		}); err != nil {
+			panic(errors.Join(ErrCouldNotSendTransferAuthorityEvent, err))
+		}
 
-			if hook := hooks.OnDeviceAuthoritySent; hook != nil {
-				hook(uint32(index), input.prev.prev.prev.remote)
-			}
+		if hook := hooks.OnDeviceAuthoritySent; hook != nil {
+			hook(uint32(index), input.prev.prev.prev.remote)
+		}
 
-			if err := mig.WaitForCompletion(); err != nil {
-				return errors.Join(registry.ErrCouldNotWaitForMigrationCompletion, err)
-			}
+		if err := mig.WaitForCompletion(); err != nil {
+			return errors.Join(registry.ErrCouldNotWaitForMigrationCompletion, err)
+		}
 
-			if err := to.SendEvent(&packets.Event{
-				Type: packets.EventCompleted,
-			}); err != nil {
-				return errors.Join(ErrCouldNotSendCompletedEvent, err)
+		if err := to.SendEvent(&packets.Event{
+			Type: packets.EventCompleted,
+		}); err != nil {
+			return errors.Join(ErrCouldNotSendCompletedEvent, err)
+		}
+
+		if hook := hooks.OnDeviceMigrationCompleted; hook != nil {
+			hook(uint32(index), input.prev.prev.prev.remote)
+		}
+
+		return nil
+	}
+
+	migratableMounter.setAddDevice(func(input makeMigratableDeviceStage, migrateToDevice MigrateToDevice) (uint32, error) {
+		addedDevicesLock.Lock()
+		defer addedDevicesLock.Unlock()
+
+		if !addedDevicesAccepted {
+			if len(stage5Inputs) == 0 {
+				return 0, ErrNoInitialDevices
 			}
 
-			if hook := hooks.OnDeviceMigrationCompleted; hook != nil {
-				hook(uint32(index), input.prev.prev.prev.remote)
+			return 0, ErrAuthorityTransferAlreadyStarted
+		}
+
+		totalDevices.Add(1)
+		index := nextDeviceIndex.Add(1) - 1
+
+		addedDevicesWg.Add(1)
+		goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
+			defer addedDevicesWg.Done()
+
+			if err := migrateDevice(int(index), migrateToStage{
+				prev: input,
+
+				migrateToDevice: migrateToDevice,
+			}, false); err != nil {
+				panic(errors.Join(ErrCouldNotMigrateToDevice, err))
 			}
+		})
+
+		return uint32(index), nil
+	})
+	defer migratableMounter.setAddDevice(nil)
 
-			return nil
+	_, deferFuncs, err := iutils.ConcurrentMap(
+		stage5Inputs,
+		func(index int, input migrateToStage, _ *struct{}, _ func(deferFunc func() error)) error {
+			return migrateDevice(index, input, true)
 		},
 	)
 
@@ -404,6 +500,13 @@ func (migratableMounter *MigratableMounter) MigrateTo(
 		panic(errors.Join(ErrCouldNotMigrateToDevice, err))
 	}
 
+	// If there were no initial devices, we never started transferring authority, so we need to stop accepting added devices here
+	addedDevicesLock.Lock()
+	addedDevicesAccepted = false
+	addedDevicesLock.Unlock()
+
+	addedDevicesWg.Wait()
+
 	for _, deferFuncs := range deferFuncs {
 		for _, deferFunc := range deferFuncs {
 			defer deferFunc() // We can safely ignore errors here since we never call `addDefer` with a function that could return an error
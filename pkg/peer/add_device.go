@@ -0,0 +1,150 @@
+package peer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+
+	"github.com/loopholelabs/drafter/pkg/mounter"
+	"github.com/loopholelabs/drafter/pkg/snapshotter"
+	"golang.org/x/sys/unix"
+)
+
+// AddDevice adds a device, i.e. a drive that was hot-attached to the VM, to an ongoing `MigrateTo` session.
+// The device node is created in the VM's directory and the device is announced to the destination after
+// `registry.EventCustomAllDevicesSent`; devices can only be added until the VM starts being suspended.
+// Once added, the device belongs to the resumed peer, so it is included in later migrations and its device
+// node is only removed when the peer is closed.
+func (migratablePeer *MigratablePeer[L, R, G]) AddDevice(
+	device mounter.MigrateToAddedDevice,
+) (*mounter.MigratedDevice, uint32, error) {
+	migratablePeer.addDeviceLock.Lock()
+	defer migratablePeer.addDeviceLock.Unlock()
+
+	if migratablePeer.addDevice == nil {
+		return nil, 0, mounter.ErrNoActiveMigration
+	}
+
+	// The name is used for the device node in the VM's directory and by the destination
+	if !mounter.IsValidDeviceName(device.Name) {
+		return nil, 0, mounter.ErrInvalidDeviceName
+	}
+
+	migratedPeer := migratablePeer.resumedPeer.migratedPeer
+	if !migratedPeer.reserveDeviceName(device.Name) {
+		return nil, 0, mounter.ErrDeviceAlreadyExists
+	}
+
+	local, dev, deferFuncs, err := mounter.CreateLocalDevice(device)
+	if err != nil {
+		// Release the name again so that adding the device can be retried
+		migratedPeer.releaseDeviceName(device.Name)
+
+		return nil, 0, errors.Join(mounter.ErrCouldNotAddDevice, err)
+	}
+
+	// Close the device again and release its name so that adding the device can be retried
+	closeDevice := func(err error) error {
+		for _, deferFunc := range slices.Backward(deferFuncs) {
+			if closeErr := deferFunc(); closeErr != nil {
+				err = errors.Join(err, closeErr)
+			}
+		}
+		migratedPeer.releaseDeviceName(device.Name)
+
+		return err
+	}
+
+	devicePath := filepath.Join("/dev", dev.Device())
+
+	deviceInfo, err := os.Stat(devicePath)
+	if err != nil {
+		return nil, 0, closeDevice(errors.Join(snapshotter.ErrCouldNotGetDeviceStat, err))
+	}
+
+	deviceStat, ok := deviceInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, 0, closeDevice(ErrCouldNotGetNBDDeviceStat)
+	}
+
+	deviceMajor := uint64(deviceStat.Rdev / 256)
+	deviceMinor := uint64(deviceStat.Rdev % 256)
+
+	deviceID := int((deviceMajor << 8) | deviceMinor)
+
+	deviceNodePath := filepath.Join(migratablePeer.resumedPeer.vmPath, device.Name)
+	if err := unix.Mknod(deviceNodePath, unix.S_IFBLK|0666, deviceID); err != nil {
+		return nil, 0, closeDevice(errors.Join(ErrCouldNotCreateDeviceNode, err))
+	}
+
+	stage2Input := migrateFromStage{
+		name: device.Name,
+
+		blockSize: device.BlockSize,
+
+		remote: false,
+
+		storage: local,
+		device:  dev,
+	}
+
+	stage4Input, unlock := newMakeMigratableDeviceStage(makeMigratableFilterStage{
+		prev: stage2Input,
+
+		makeMigratableDevice: mounter.MakeMigratableDevice{
+			Name: device.Name,
+
+			Expiry: device.Expiry,
+		},
+	})
+
+	migrationDeviceID, err := migratablePeer.addDevice(stage4Input, mounter.MigrateToDevice{
+		Name: device.Name,
+
+		MaxDirtyBlocks: device.MaxDirtyBlocks,
+		MinCycles:      device.MinCycles,
+		MaxCycles:      device.MaxCycles,
+
+		CycleThrottle: device.CycleThrottle,
+	})
+	if err != nil {
+		err = errors.Join(mounter.ErrCouldNotAddDevice, err)
+
+		_ = unlock() // We can safely ignore errors here since unlocking never fails
+
+		// Remove the device node before closing the device
+		if removeErr := os.Remove(deviceNodePath); removeErr != nil {
+			err = errors.Join(err, ErrCouldNotRemoveDeviceNode, removeErr)
+		}
+
+		return nil, 0, closeDevice(err)
+	}
+
+	// The storage is only locked for this migration, but the device itself outlives it
+	migratablePeer.addedDeviceDefersLock.Lock()
+	migratablePeer.addedDeviceDefers = append(migratablePeer.addedDeviceDefers, unlock)
+	migratablePeer.addedDeviceDefersLock.Unlock()
+
+	migratablePeer.stage4Inputs = append(migratablePeer.stage4Inputs, stage4Input)
+
+	// The device node is removed before the device is closed
+	migratedPeer.addedDeviceCloseFuncsLock.Lock()
+	migratedPeer.addedDeviceCloseFuncs = append(migratedPeer.addedDeviceCloseFuncs, deferFuncs...)
+	migratedPeer.addedDeviceCloseFuncs = append(migratedPeer.addedDeviceCloseFuncs, func() error {
+		_ = os.Remove(deviceNodePath) // We ignore errors here since the VM's directory might have already been removed
+
+		return nil
+	})
+	migratedPeer.addedDeviceCloseFuncsLock.Unlock()
+
+	migratedPeer.stage2InputsLock.Lock()
+	migratedPeer.stage2Inputs = append(migratedPeer.stage2Inputs, stage2Input)
+	migratedPeer.stage2InputsLock.Unlock()
+
+	return &mounter.MigratedDevice{
+		Name: device.Name,
+		Path: devicePath,
+	}, migrationDeviceID, nil
+}
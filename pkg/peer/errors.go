@@ -8,6 +8,7 @@ var (
 	ErrCouldNotStartRunner                = errors.New("could not start runner")
 	ErrPeerContextCancelled               = errors.New("peer context cancelled")
 	ErrCouldNotCreateDeviceNode           = errors.New("could not create device node")
+	ErrCouldNotRemoveDeviceNode           = errors.New("could not remove device node")
 	ErrCouldNotCloseMigratedPeer          = errors.New("could not close migrated peer")
 	ErrCouldNotOpenConfigFile             = errors.New("could not open config file")
 	ErrCouldNotDecodeConfigFile           = errors.New("could not decode config file")
@@ -3,16 +3,13 @@ package peer
 import (
 	"context"
 	"errors"
+	"slices"
 
 	"github.com/loopholelabs/drafter/internal/utils"
 	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/mounter"
 	"github.com/loopholelabs/drafter/pkg/runner"
 	"github.com/loopholelabs/goroutine-manager/pkg/manager"
-	"github.com/loopholelabs/silo/pkg/storage/blocks"
-	"github.com/loopholelabs/silo/pkg/storage/dirtytracker"
-	"github.com/loopholelabs/silo/pkg/storage/modules"
-	"github.com/loopholelabs/silo/pkg/storage/volatilitymonitor"
 )
 
 type ResumedPeer[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any] struct {
@@ -23,7 +20,22 @@ type ResumedPeer[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any] stru
 
 	resumedRunner *runner.ResumedRunner[L, R, G]
 
-	stage2Inputs []migrateFromStage
+	vmPath string
+
+	migratedPeer *MigratedPeer[L, R, G]
+}
+
+func newMakeMigratableDeviceStage(input makeMigratableFilterStage) (makeMigratableDeviceStage, func() error) {
+	migratableStorage, unlock := mounter.NewMigratableStorage(input.prev.storage, input.prev.device, input.prev.blockSize, input.makeMigratableDevice.Expiry)
+
+	return makeMigratableDeviceStage{
+		prev: input,
+
+		storage:     migratableStorage.Storage,
+		orderer:     migratableStorage.Orderer,
+		totalBlocks: migratableStorage.TotalBlocks,
+		dirtyRemote: migratableStorage.DirtyRemote,
+	}, unlock
 }
 
 func (resumedPeer *ResumedPeer[L, R, G]) MakeMigratable(
@@ -48,8 +60,13 @@ func (resumedPeer *ResumedPeer[L, R, G]) MakeMigratable(
 	defer goroutineManager.StopAllGoroutines()
 	defer goroutineManager.CreateBackgroundPanicCollector()()
 
+	// Devices can still be added while we make the peer migratable, so we need to copy the devices under the lock
+	resumedPeer.migratedPeer.stage2InputsLock.Lock()
+	stage2Inputs := slices.Clone(resumedPeer.migratedPeer.stage2Inputs)
+	resumedPeer.migratedPeer.stage2InputsLock.Unlock()
+
 	stage3Inputs := []makeMigratableFilterStage{}
-	for _, input := range resumedPeer.stage2Inputs {
+	for _, input := range stage2Inputs {
 		var makeMigratableDevice *mounter.MakeMigratableDevice
 		for _, device := range devices {
 			if device.Name == input.name {
@@ -78,34 +95,23 @@ func (resumedPeer *ResumedPeer[L, R, G]) MakeMigratable(
 	migratablePeer.stage4Inputs, deferFuncs, err = utils.ConcurrentMap(
 		stage3Inputs,
 		func(index int, input makeMigratableFilterStage, output *makeMigratableDeviceStage, addDefer func(deferFunc func() error)) error {
-			output.prev = input
-
-			dirtyLocal, dirtyRemote := dirtytracker.NewDirtyTracker(input.prev.storage, int(input.prev.blockSize))
-			output.dirtyRemote = dirtyRemote
-			monitor := volatilitymonitor.NewVolatilityMonitor(dirtyLocal, int(input.prev.blockSize), input.makeMigratableDevice.Expiry)
-
-			local := modules.NewLockable(monitor)
-			output.storage = local
-			addDefer(func() error {
-				local.Unlock()
-
-				return nil
-			})
-
-			input.prev.device.SetProvider(local)
-
-			totalBlocks := (int(local.Size()) + int(input.prev.blockSize) - 1) / int(input.prev.blockSize)
-			output.totalBlocks = totalBlocks
-
-			orderer := blocks.NewPriorityBlockOrder(totalBlocks, monitor)
-			output.orderer = orderer
-			orderer.AddAll()
+			var unlock func() error
+			*output, unlock = newMakeMigratableDeviceStage(input)
+			addDefer(unlock)
 
 			return nil
 		},
 	)
 
 	migratablePeer.Close = func() {
+		// Devices that were added during a migration are unlocked after the devices that were made migratable here
+		migratablePeer.addedDeviceDefersLock.Lock()
+		defer migratablePeer.addedDeviceDefersLock.Unlock()
+
+		for _, deferFunc := range migratablePeer.addedDeviceDefers {
+			defer deferFunc() // We can safely ignore errors here since we never add a function that could return an error
+		}
+
 		// Make sure that we schedule the `deferFuncs` even if we get an error
 		for _, deferFuncs := range deferFuncs {
 			for _, deferFunc := range deferFuncs {
@@ -107,8 +107,6 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 		deviceCloseFuncsLock sync.Mutex
 		deviceCloseFuncs     []func() error
 
-		stage2InputsLock sync.Mutex
-
 		pro *protocol.RW
 	)
 	if len(readers) > 0 && len(writers) > 0 { // Only open the protocol if we want passed in readers and writers
@@ -127,15 +125,38 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 					func(di *packets.DevInfo) storage.Provider {
 						// No need to `defer goroutineManager.HandlePanics` here - panics bubble upwards
 
-						base := ""
+						// We reserve the name in the same critical section as the check so that concurrent device infos can't both pass it
+						if !migratedPeer.reserveDeviceName(di.Name) {
+							panic(mounter.ErrDeviceAlreadyExists)
+						}
+
+						var (
+							base       = ""
+							configured = false
+						)
 						for _, device := range devices {
 							if di.Name == device.Name {
 								base = device.Base
+								configured = true
 
 								break
 							}
 						}
 
+						// This device isn't configured locally, i.e. because it was hot-attached on the source and announced after
+						// `EventCustomAllDevicesSent`, so we ask the hook where to store it. We can't use `allRemoteDevicesReceived` to
+						// detect this since events are handled concurrently with device infos.
+						if !configured {
+							// The name is chosen by the remote, so make sure that it can't escape the directory we store it in
+							if !mounter.IsValidDeviceName(di.Name) {
+								panic(mounter.ErrInvalidDeviceName)
+							}
+
+							if hook := hooks.OnRemoteDeviceAdded; hook != nil {
+								base = hook(index, di.Name)
+							}
+						}
+
 						if strings.TrimSpace(base) == "" {
 							panic(terminator.ErrUnknownDeviceName)
 						}
@@ -198,7 +219,7 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 
 						dev.SetProvider(local)
 
-						stage2InputsLock.Lock()
+						migratedPeer.stage2InputsLock.Lock()
 						migratedPeer.stage2Inputs = append(migratedPeer.stage2Inputs, migrateFromStage{
 							name: di.Name,
 
@@ -210,7 +231,7 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 							storage: local,
 							device:  dev,
 						})
-						stage2InputsLock.Unlock()
+						migratedPeer.stage2InputsLock.Unlock()
 
 						devicePath := filepath.Join("/dev", dev.Device())
 
@@ -369,6 +390,18 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 			}(closeFunc)
 		}
 
+		// Devices that were added while migrating the peer onwards are closed before the devices that were migrated here
+		migratedPeer.addedDeviceCloseFuncsLock.Lock()
+		defer migratedPeer.addedDeviceCloseFuncsLock.Unlock()
+
+		for _, closeFunc := range migratedPeer.addedDeviceCloseFuncs {
+			defer func(closeFunc func() error) {
+				if err := closeFunc(); err != nil {
+					errs = errors.Join(errs, err)
+				}
+			}(closeFunc)
+		}
+
 		return
 	}
 
@@ -413,12 +446,16 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 
 	stage1Inputs := []MigrateFromDevice[L, R, G]{}
 	for _, input := range devices {
-		if slices.ContainsFunc(
+		migratedPeer.stage2InputsLock.Lock()
+		received := slices.ContainsFunc(
 			migratedPeer.stage2Inputs,
 			func(r migrateFromStage) bool {
 				return input.Name == r.name
 			},
-		) {
+		)
+		migratedPeer.stage2InputsLock.Unlock()
+
+		if received {
 			continue
 		}
 
@@ -496,7 +533,7 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 
 				dev.SetProvider(local)
 
-				stage2InputsLock.Lock()
+				migratedPeer.stage2InputsLock.Lock()
 				migratedPeer.stage2Inputs = append(migratedPeer.stage2Inputs, migrateFromStage{
 					name: input.Name,
 
@@ -508,7 +545,7 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 					storage: local,
 					device:  dev,
 				})
-				stage2InputsLock.Unlock()
+				migratedPeer.stage2InputsLock.Unlock()
 
 				devicePath = filepath.Join("/dev", dev.Device())
 			}
@@ -0,0 +1,9 @@
+package peer
+
+import (
+	"context"
+)
+
+func (resumedPeer *ResumedPeer[L, R, G]) Msync(ctx context.Context) error {
+	return resumedPeer.resumedRunner.Msync(ctx)
+}
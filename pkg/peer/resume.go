@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/loopholelabs/drafter/pkg/ipc"
@@ -21,7 +23,42 @@ type MigratedPeer[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any] str
 	devices []MigrateFromDevice[L, R, G]
 	runner  *runner.Runner[L, R, G]
 
-	stage2Inputs []migrateFromStage
+	stage2InputsLock    sync.Mutex
+	stage2Inputs        []migrateFromStage
+	reservedDeviceNames map[string]struct{}
+
+	addedDeviceCloseFuncsLock sync.Mutex
+	addedDeviceCloseFuncs     []func() error
+}
+
+// reserveDeviceName reserves `name` for a device that is being set up, but not yet in `stage2Inputs`;
+// it returns false if another device already uses or reserved the name
+func (migratedPeer *MigratedPeer[L, R, G]) reserveDeviceName(name string) bool {
+	migratedPeer.stage2InputsLock.Lock()
+	defer migratedPeer.stage2InputsLock.Unlock()
+
+	if _, ok := migratedPeer.reservedDeviceNames[name]; ok || slices.ContainsFunc(
+		migratedPeer.stage2Inputs,
+		func(r migrateFromStage) bool {
+			return name == r.name
+		},
+	) {
+		return false
+	}
+
+	if migratedPeer.reservedDeviceNames == nil {
+		migratedPeer.reservedDeviceNames = map[string]struct{}{}
+	}
+	migratedPeer.reservedDeviceNames[name] = struct{}{}
+
+	return true
+}
+
+func (migratedPeer *MigratedPeer[L, R, G]) releaseDeviceName(name string) {
+	migratedPeer.stage2InputsLock.Lock()
+	defer migratedPeer.stage2InputsLock.Unlock()
+
+	delete(migratedPeer.reservedDeviceNames, name)
 }
 
 func (migratedPeer *MigratedPeer[L, R, G]) Resume(
@@ -43,7 +80,9 @@ func (migratedPeer *MigratedPeer[L, R, G]) Resume(
 			return nil
 		},
 
-		stage2Inputs: migratedPeer.stage2Inputs,
+		vmPath: migratedPeer.runner.VMPath,
+
+		migratedPeer: migratedPeer,
 	}
 
 	configBasePath := ""